@@ -0,0 +1,664 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ServerAdmins holds the sessions that have logged in with the admin token.
+var ServerAdmins = NewMuMap[string, bool]()
+
+// Bans maps banned addresses to the reason they were banned.
+var Bans = NewMuMap[string, string]()
+
+var (
+	adminErrNoRoom     = errors.New("room does not exist")
+	adminErrRoomExists = errors.New("a room with that name already exists")
+	adminErrLobby      = errors.New("the lobby can't be closed or renamed")
+	adminErrNotOnline  = errors.New("user is not online")
+	adminErrNotAddress = errors.New("not an IP address")
+	adminErrOwnAddress = errors.New("you can't ban your own address")
+)
+
+type AdminRoom struct {
+	Name    string        `json:"name"`
+	Topic   string        `json:"topic"`
+	Rules   *Rules        `json:"rules"`
+	Members []AdminMember `json:"members"`
+}
+
+type AdminMember struct {
+	Nick      string    `json:"nick"`
+	Level     string    `json:"level"`
+	Addr      string    `json:"addr"`
+	Bot       bool      `json:"bot"`
+	Connected time.Time `json:"connected"`
+
+	// Queue is how many messages are waiting in the client's Send channel,
+	// out of QueueSize
+	Queue     int `json:"queue"`
+	QueueSize int `json:"queue_size"`
+}
+
+// AdminRooms describes every room and who is in it, sorted by name.
+func AdminRooms() []AdminRoom {
+	rooms := make([]AdminRoom, 0)
+	for _, room := range Rooms.Values() {
+		room.Query(func(room *Room) {
+			rules := *room.Rules
+			info := AdminRoom{
+				Name:    room.Name,
+				Topic:   room.Rules.topic,
+				Rules:   &rules,
+				Members: make([]AdminMember, 0, len(room.Clients)),
+			}
+
+			for client, data := range room.Clients {
+				info.Members = append(info.Members, AdminMember{
+					Nick:      data.Nick,
+					Level:     data.OPLevel.String(),
+					Addr:      client.Addr,
+					Bot:       client.Bot != nil,
+					Connected: client.Connected,
+					Queue:     len(client.Send),
+					QueueSize: cap(client.Send),
+				})
+			}
+
+			sort.Slice(info.Members, func(i, j int) bool {
+				return info.Members[i].Nick < info.Members[j].Nick
+			})
+
+			rooms = append(rooms, info)
+		})
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+
+	return rooms
+}
+
+// CloseRoom sends everyone in a room back to the lobby and shuts it down.
+func CloseRoom(name string, reason string) error {
+	if name == DefaultRoom {
+		return adminErrLobby
+	}
+
+	room, ok := Rooms.Get(name)
+	if !ok || !room.Query(func(room *Room) { room.shutdown(reason) }) {
+		return adminErrNoRoom
+	}
+
+	slog.Info("admin closed room", "room", name, "reason", reason)
+	return nil
+}
+
+// RenameRoom moves a room to a new name, keeping everyone in it.
+func RenameRoom(name string, newName string) error {
+	if name == DefaultRoom || newName == DefaultRoom {
+		return adminErrLobby
+	}
+
+	room, ok := Rooms.Get(name)
+	if !ok {
+		return adminErrNoRoom
+	}
+
+	Rooms.Mu.Lock()
+	if _, exists := Rooms.M[newName]; exists {
+		Rooms.Mu.Unlock()
+		return adminErrRoomExists
+	}
+	delete(Rooms.M, name)
+	Rooms.M[newName] = room
+	Rooms.Mu.Unlock()
+
+	ok = room.Query(func(room *Room) {
+		room.Name = newName
+
+		// Incoming hooks find their room by name. HookHandler reads them
+		// without the room, so they're replaced rather than changed.
+		for i, hook := range room.hooks {
+			renamed := *hook
+			renamed.Room = newName
+
+			room.hooks[i] = &renamed
+			IncomingHooks.Set(renamed.Token, &renamed)
+		}
+
+		room.enqueue(RoomMessage{
+			Type: MessageTypeRoom,
+			Body: newName,
+		}.Fill())
+
+		room.enqueue(RoomMessage{
+			Type: MessageTypeNotice,
+			Body: fmt.Sprintf("this room was renamed from %s to %s", name, newName),
+		}.Fill())
+	})
+	if !ok {
+		Rooms.Delete(newName)
+		return adminErrNoRoom
+	}
+
+	slog.Info("admin renamed room", "room", name, "name", newName)
+	return nil
+}
+
+// BroadcastAll sends a server notice to every room, returning how many
+// rooms it reached.
+func BroadcastAll(body string) int {
+	n := 0
+	for _, room := range Rooms.Values() {
+		select {
+		case room.Internal <- RoomMessage{
+			Type: MessageTypeNotice,
+			Body: fmt.Sprintf("[server] %s", body),
+		}.Fill():
+			n++
+		default:
+		}
+	}
+
+	slog.Info("admin broadcast", "rooms", n, "body", body)
+	return n
+}
+
+// member finds the client with the given nick in a room.
+func member(roomName string, nick string) (*Client, error) {
+	room, ok := Rooms.Get(roomName)
+	if !ok {
+		return nil, adminErrNoRoom
+	}
+
+	var client *Client
+	ok = room.Query(func(room *Room) {
+		for other, data := range room.Clients {
+			if data.Nick == nick && other.Bot == nil {
+				client = other
+				return
+			}
+		}
+	})
+	if !ok {
+		return nil, adminErrNoRoom
+	}
+	if client == nil {
+		return nil, adminErrNotOnline
+	}
+
+	return client, nil
+}
+
+// MemberAddr returns the address of the user with the given nick in a
+// room, for banning.
+func MemberAddr(roomName string, nick string) (string, error) {
+	client, err := member(roomName, nick)
+	if err != nil {
+		return "", err
+	}
+
+	return addrHost(client.Addr), nil
+}
+
+// Disconnect closes the connection of the user with the given nick in a
+// room. Nicks are only unique within a room, so the room is required.
+func Disconnect(roomName string, nick string) error {
+	client, err := member(roomName, nick)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("admin disconnected client", clientAttr(client, nick), "room", roomName)
+	return client.Transport.Close()
+}
+
+// Ban bans an address from the server and disconnects everyone connected
+// from it. It returns the banned address.
+func Ban(target string, reason string) (string, error) {
+	addr := addrHost(target)
+	if net.ParseIP(addr) == nil {
+		return "", fmt.Errorf("%w: %s", adminErrNotAddress, target)
+	}
+
+	Bans.Set(addr, reason)
+	slog.Info("admin banned address", "addr", addr, "reason", reason)
+
+	for _, room := range Rooms.Values() {
+		room.Query(func(room *Room) {
+			for client := range room.Clients {
+				if client.Bot == nil && addrHost(client.Addr) == addr {
+					_ = client.Transport.Close()
+				}
+			}
+		})
+	}
+
+	return addr, nil
+}
+
+// checkOwnBan stops an admin banning the address they're acting from,
+// which would lock them out.
+func checkOwnBan(target string, own string) error {
+	if addrHost(target) == addrHost(own) {
+		return adminErrOwnAddress
+	}
+
+	return nil
+}
+
+func Unban(addr string) bool {
+	if _, ok := Bans.Get(addr); !ok {
+		return false
+	}
+
+	Bans.Delete(addr)
+	slog.Info("admin unbanned address", "addr", addr)
+	return true
+}
+
+// BanMiddleware turns away requests from banned addresses.
+func BanMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, banned := Bans.Get(addrHost(r.RemoteAddr)); banned {
+			http.Error(w, "Banned.", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shutdown sends every user back to the lobby and marks the room to stop
+// once the current query finishes.
+func (r *Room) shutdown(reason string) {
+	body := "this room was closed by a server admin"
+	if reason != "" {
+		body = fmt.Sprintf("%s: %s", body, reason)
+	}
+
+	for client, data := range r.Clients {
+		if data.Nick != "" {
+			Directory.Remove(data.Nick, client)
+		}
+
+		if client.Bot != nil {
+			continue
+		}
+
+		_ = r.send(client, RoomMessage{
+			Type: MessageTypeNotice,
+			Body: body,
+		}.Fill())
+
+		sendExit(client)
+	}
+
+	clear(r.Clients)
+	r.unlist()
+	r.closing = true
+}
+
+// serverAdmin handles /sa, which runs on the client rather than a room so
+// it can act on every room.
+func (c *Client) serverAdmin(args []string) {
+	reply := func(messageType MessageType, body string) {
+		c.Send <- RoomMessage{
+			Type: messageType,
+			Body: body,
+		}.Fill()
+	}
+
+	sub, rest := args[0], ""
+	if len(args) > 1 {
+		rest = args[1]
+	}
+
+	if sub == "login" {
+		if config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(config.AdminToken), []byte(rest)) != 1 {
+			slog.Warn("failed admin login", clientAttr(c, ""))
+			reply(MessageTypeError, "invalid admin token")
+			return
+		}
+
+		slog.Info("admin logged in", clientAttr(c, ""))
+		ServerAdmins.Set(c.ID, true)
+		reply(MessageTypeCommand, "logged in as a server admin")
+		return
+	}
+
+	if admin, _ := ServerAdmins.Get(c.ID); !admin {
+		reply(MessageTypeError, "you must log in with /sa login <token> first")
+		return
+	}
+
+	fields := strings.Fields(rest)
+	need := func(n int) bool {
+		if len(fields) < n {
+			reply(MessageTypeError, fmt.Sprintf("missing arguments for: /sa %s\nUsage:\n  %s", sub, Commands["sa"].Help))
+			return false
+		}
+		return true
+	}
+
+	switch sub {
+	case "logout":
+		ServerAdmins.Delete(c.ID)
+		reply(MessageTypeCommand, "logged out")
+
+	case "rooms":
+		rooms := AdminRooms()
+		lines := make([]string, 0, len(rooms)+1)
+		lines = append(lines, "rooms:")
+		for _, room := range rooms {
+			lines = append(lines, fmt.Sprintf("  %s (%d members)", room.Name, len(room.Members)))
+		}
+		reply(MessageTypeCommand, strings.Join(lines, "\n"))
+
+	case "clients":
+		lines := []string{"clients:"}
+		for _, room := range AdminRooms() {
+			if len(fields) > 0 && room.Name != fields[0] {
+				continue
+			}
+			for _, member := range room.Members {
+				if member.Bot {
+					continue
+				}
+				nick := member.Nick
+				if nick == "" {
+					nick = "(no nick)"
+				}
+				lines = append(lines, fmt.Sprintf("  %s in %s as %s from %s", nick, room.Name, member.Level, member.Addr))
+			}
+		}
+		reply(MessageTypeCommand, strings.Join(lines, "\n"))
+
+	case "close":
+		if !need(1) {
+			return
+		}
+		_, reason, _ := strings.Cut(rest, " ")
+		if err := CloseRoom(fields[0], strings.TrimSpace(reason)); err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+		reply(MessageTypeCommand, fmt.Sprintf("closed room %s", fields[0]))
+
+	case "rename":
+		if !need(2) {
+			return
+		}
+		if err := RenameRoom(fields[0], fields[1]); err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+		reply(MessageTypeCommand, fmt.Sprintf("renamed room %s to %s", fields[0], fields[1]))
+
+	case "broadcast":
+		if !need(1) {
+			return
+		}
+		reply(MessageTypeCommand, fmt.Sprintf("broadcast to %d rooms", BroadcastAll(rest)))
+
+	case "kill":
+		if !need(2) {
+			return
+		}
+		if err := Disconnect(fields[0], fields[1]); err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+		reply(MessageTypeCommand, fmt.Sprintf("disconnected %s from %s", fields[1], fields[0]))
+
+	case "ban":
+		if !need(1) {
+			return
+		}
+
+		// Either an address, or a room and the nick of someone in it
+		target, reason := fields[0], fields[1:]
+		if net.ParseIP(target) == nil {
+			if !need(2) {
+				return
+			}
+
+			addr, err := MemberAddr(fields[0], fields[1])
+			if err != nil {
+				reply(MessageTypeError, fmt.Sprintf("%s in %s: %s", fields[1], fields[0], err))
+				return
+			}
+			target, reason = addr, fields[2:]
+		}
+
+		if err := checkOwnBan(target, c.Addr); err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+
+		addr, err := Ban(target, strings.Join(reason, " "))
+		if err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+		reply(MessageTypeCommand, fmt.Sprintf("banned %s", addr))
+
+	case "unban":
+		if !need(1) {
+			return
+		}
+		if !Unban(fields[0]) {
+			reply(MessageTypeError, fmt.Sprintf("%s is not banned", fields[0]))
+			return
+		}
+		reply(MessageTypeCommand, fmt.Sprintf("unbanned %s", fields[0]))
+
+	case "bans":
+		lines := []string{"bans:"}
+		Bans.Mu.RLock()
+		for addr, reason := range Bans.M {
+			lines = append(lines, fmt.Sprintf("  %s: %s", addr, reason))
+		}
+		Bans.Mu.RUnlock()
+		reply(MessageTypeCommand, strings.Join(lines, "\n"))
+
+	case "trace":
+		if !need(1) {
+			return
+		}
+		room, ok := Rooms.Get(fields[0])
+		if !ok {
+			reply(MessageTypeError, adminErrNoRoom.Error())
+			return
+		}
+
+		var on bool
+		if len(fields) > 1 {
+			switch fields[1] {
+			case "on":
+				on = true
+			case "off":
+			default:
+				reply(MessageTypeError, fmt.Sprintf("invalid trace setting: %s, must be on or off", fields[1]))
+				return
+			}
+		}
+		if !room.Query(func(room *Room) {
+			if len(fields) == 1 {
+				on = !room.trace
+			}
+			room.trace = on
+		}) {
+			reply(MessageTypeError, adminErrNoRoom.Error())
+			return
+		}
+
+		state := "off"
+		if on {
+			state = "on"
+		}
+		slog.Info("admin set room trace", "room", fields[0], "trace", on)
+		reply(MessageTypeCommand, fmt.Sprintf("tracing %s for room %s", state, fields[0]))
+
+	default:
+		reply(MessageTypeError, fmt.Sprintf("unknown subcommand: %s", sub))
+	}
+}
+
+func AdminRoutes(r chi.Router) {
+	r.Use(apiAdmin)
+
+	r.Get("/rooms", apiAdminRooms)
+	r.Delete("/rooms/{name}", apiAdminCloseRoom)
+	r.Post("/rooms/{name}/rename", apiAdminRenameRoom)
+	r.Post("/broadcast", apiAdminBroadcast)
+	r.Post("/rooms/{name}/clients/{nick}/disconnect", apiAdminDisconnect)
+	r.Get("/bans", apiAdminBans)
+	r.Put("/bans/{target}", apiAdminBan)
+	r.Delete("/bans/{target}", apiAdminUnban)
+}
+
+type AdminRequest struct {
+	Name   string `json:"name"`
+	Body   string `json:"body"`
+	Reason string `json:"reason"`
+}
+
+func adminStatus(err error) int {
+	switch {
+	case errors.Is(err, adminErrNoRoom), errors.Is(err, adminErrNotOnline):
+		return http.StatusNotFound
+	case errors.Is(err, adminErrRoomExists):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// adminRequest decodes an optional JSON body.
+func adminRequest(w http.ResponseWriter, r *http.Request) (AdminRequest, bool) {
+	var req AdminRequest
+	if r.ContentLength == 0 {
+		return req, true
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON")
+		return req, false
+	}
+
+	return req, true
+}
+
+func apiAdminRooms(w http.ResponseWriter, _ *http.Request) {
+	apiJSON(w, http.StatusOK, AdminRooms())
+}
+
+func apiAdminCloseRoom(w http.ResponseWriter, r *http.Request) {
+	req, ok := adminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := CloseRoom(roomParam(r), req.Reason); err != nil {
+		apiError(w, adminStatus(err), err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiAdminRenameRoom(w http.ResponseWriter, r *http.Request) {
+	req, ok := adminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		apiError(w, http.StatusBadRequest, "missing new name")
+		return
+	}
+
+	if err := RenameRoom(roomParam(r), req.Name); err != nil {
+		apiError(w, adminStatus(err), err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiAdminBroadcast(w http.ResponseWriter, r *http.Request) {
+	req, ok := adminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if strings.TrimSpace(req.Body) == "" {
+		apiError(w, http.StatusBadRequest, "message body is empty")
+		return
+	}
+
+	apiJSON(w, http.StatusOK, map[string]int{"rooms": BroadcastAll(req.Body)})
+}
+
+func apiAdminDisconnect(w http.ResponseWriter, r *http.Request) {
+	if err := Disconnect(roomParam(r), pathParam(r, "nick")); err != nil {
+		apiError(w, adminStatus(err), err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiAdminBans(w http.ResponseWriter, _ *http.Request) {
+	Bans.Mu.RLock()
+	bans := make(map[string]string, len(Bans.M))
+	for addr, reason := range Bans.M {
+		bans[addr] = reason
+	}
+	Bans.Mu.RUnlock()
+
+	apiJSON(w, http.StatusOK, bans)
+}
+
+func apiAdminBan(w http.ResponseWriter, r *http.Request) {
+	req, ok := adminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	target := pathParam(r, "target")
+	if err := checkOwnBan(target, r.RemoteAddr); err != nil {
+		apiError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	addr, err := Ban(target, req.Reason)
+	if err != nil {
+		apiError(w, adminStatus(err), err.Error())
+		return
+	}
+
+	apiJSON(w, http.StatusOK, map[string]string{"addr": addr})
+}
+
+func apiAdminUnban(w http.ResponseWriter, r *http.Request) {
+	if !Unban(pathParam(r, "target")) {
+		apiError(w, http.StatusNotFound, "not banned")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
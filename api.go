@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+	apiTimeout      = 5 * time.Second
+)
+
+type apiKeyContextKey struct{}
+
+type RoomSummary struct {
+	Name    string `json:"name"`
+	Topic   string `json:"topic"`
+	Members int    `json:"members"`
+}
+
+type RoomInfo struct {
+	Name    string   `json:"name"`
+	Rules   *Rules   `json:"rules"`
+	Topic   string   `json:"topic"`
+	Members []string `json:"members"`
+}
+
+type PostMessageRequest struct {
+	Body string `json:"body"`
+}
+
+func APIRoutes(r chi.Router) {
+	r.Use(apiAuth)
+
+	r.Route("/admin", AdminRoutes)
+
+	r.Get("/rooms", apiListRooms)
+	r.Route("/rooms/{name}", func(r chi.Router) {
+		r.Get("/", apiGetRoom)
+		r.Get("/messages", apiGetMessages)
+		r.Post("/messages", apiPostMessage)
+
+		r.Group(func(r chi.Router) {
+			r.Use(apiAdmin)
+			r.Put("/rules", apiPutRules)
+			r.Delete("/rules", apiDeleteRules)
+			r.Get("/audit", apiGetAudit)
+		})
+	})
+}
+
+func apiAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Key")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+
+		key, ok := config.APIKey(token)
+		if !ok {
+			apiError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+func apiAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !apiKeyFrom(r).Admin {
+			apiError(w, http.StatusForbidden, "admin API key required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func apiKeyFrom(r *http.Request) APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(APIKey)
+	return key
+}
+
+func apiJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	apiJSON(w, status, map[string]string{"error": message})
+}
+
+// apiRoom runs fn on the named room's goroutine, writing a 404 if the room
+// doesn't exist or closes first.
+func apiRoom(w http.ResponseWriter, r *http.Request, fn func(room *Room)) bool {
+	room, ok := Rooms.Get(roomParam(r))
+	if !ok || !room.Query(fn) {
+		apiError(w, http.StatusNotFound, "room not found")
+		return false
+	}
+
+	return true
+}
+
+func apiListRooms(w http.ResponseWriter, _ *http.Request) {
+	rooms := make([]RoomSummary, 0)
+	for _, room := range Rooms.Values() {
+		room.Query(func(room *Room) {
+			rooms = append(rooms, RoomSummary{
+				Name:    room.Name,
+				Topic:   room.Rules.topic,
+				Members: len(room.Nicks()),
+			})
+		})
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+
+	apiJSON(w, http.StatusOK, rooms)
+}
+
+func apiGetRoom(w http.ResponseWriter, r *http.Request) {
+	var info RoomInfo
+	ok := apiRoom(w, r, func(room *Room) {
+		rules := *room.Rules
+		info = RoomInfo{
+			Name:    room.Name,
+			Rules:   &rules,
+			Topic:   room.Rules.topic,
+			Members: room.Nicks(),
+		}
+	})
+
+	if ok {
+		apiJSON(w, http.StatusOK, info)
+	}
+}
+
+func apiGetMessages(w http.ResponseWriter, r *http.Request) {
+	limit := apiDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apiError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(n, apiMaxLimit)
+	}
+
+	var messages []RoomMessage
+	var found bool
+	ok := apiRoom(w, r, func(room *Room) {
+		messages, found = room.History(r.URL.Query().Get("before"), limit)
+	})
+
+	switch {
+	case !ok:
+	case !found:
+		apiError(w, http.StatusBadRequest, "no message with that id")
+	default:
+		apiJSON(w, http.StatusOK, messages)
+	}
+}
+
+func apiPostMessage(w http.ResponseWriter, r *http.Request) {
+	var req PostMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if strings.TrimSpace(req.Body) == "" {
+		apiError(w, http.StatusBadRequest, "message body is empty")
+		return
+	}
+
+	room, ok := Rooms.Get(roomParam(r))
+	if !ok {
+		apiError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	key := apiKeyFrom(r)
+	identity := ClientDataExternal{
+		Nick:    key.Nick,
+		Color:   key.Color,
+		OPLevel: OPLevelUser,
+	}
+	if identity.Color == "" {
+		identity.Color = messageColors[MessageTypeMessage]
+	}
+
+	timeout := time.After(apiTimeout)
+
+	reply := make(chan error, 1)
+	select {
+	case room.External <- ClientMessage{
+		Type:     MessageTypeMessage,
+		Body:     req.Body,
+		Identity: &identity,
+		Reply:    reply,
+	}:
+	case <-room.closed:
+		apiError(w, http.StatusNotFound, "room not found")
+		return
+	case <-timeout:
+		apiError(w, http.StatusGatewayTimeout, "room did not respond")
+		return
+	}
+
+	select {
+	case err := <-reply:
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusAccepted)
+		case errors.Is(err, roomErrRateLimited):
+			apiError(w, http.StatusTooManyRequests, err.Error())
+		default:
+			apiError(w, http.StatusForbidden, err.Error())
+		}
+	case <-room.closed:
+		apiError(w, http.StatusNotFound, "room not found")
+	case <-timeout:
+		apiError(w, http.StatusGatewayTimeout, "room did not respond")
+	}
+}
+
+func apiPutRules(w http.ResponseWriter, r *http.Request) {
+	var update RulesUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	var rules Rules
+	ok := apiRoom(w, r, func(room *Room) {
+		room.Rules.Apply(update)
+		rules = *room.Rules
+	})
+
+	if ok {
+		apiJSON(w, http.StatusOK, &rules)
+	}
+}
+
+func apiDeleteRules(w http.ResponseWriter, r *http.Request) {
+	// The lobby's rules are set at startup and keep chat out of it
+	if roomParam(r) == DefaultRoom {
+		apiError(w, http.StatusForbidden, "the lobby's rules can't be reset")
+		return
+	}
+
+	var rules Rules
+	ok := apiRoom(w, r, func(room *Room) {
+		room.Rules.Reset()
+		rules = *room.Rules
+	})
+
+	if ok {
+		apiJSON(w, http.StatusOK, &rules)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	auditSize         = 500
+	auditDefaultLimit = 20
+
+	auditOutcomeOK = "ok"
+)
+
+// AuditEntry records a privileged command run in a room. Outcome is "ok",
+// or why the command failed.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Addr    string    `json:"addr,omitempty"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Args    []string  `json:"args"`
+	Outcome string    `json:"outcome"`
+}
+
+func (e AuditEntry) String() string {
+	line := fmt.Sprintf("[%s] %s: /%s", e.Time.Format("2006-01-02 15:04:05"), e.Actor, e.Action)
+	if len(e.Args) > 0 {
+		line += " " + strings.Join(e.Args, " ")
+	}
+	if e.Addr != "" {
+		line += fmt.Sprintf(" (%s)", e.Addr)
+	}
+	if e.Outcome != auditOutcomeOK {
+		line += fmt.Sprintf(" [%s]", e.Outcome)
+	}
+	return line
+}
+
+// audited reports whether a command run by someone with the given level
+// belongs in the audit log. Changing the topic is checked by its handler
+// rather than the command's level.
+func audited(command *Command, level OPLevel) bool {
+	if command.OPLevel >= OPLevelAdmin {
+		return true
+	}
+
+	return command.Name == "topic" && len(command.Args) > 0 && level >= OPLevelAdmin
+}
+
+// auditEntry starts an audit log entry for a command, before it runs.
+// Arguments the command marks as secret are redacted, and a first argument
+// naming someone in the room is recorded as the target.
+func (r *Room) auditEntry(message ClientMessage, data ClientDataExternal, command *Command) *AuditEntry {
+	args := slices.Clone(command.Args)
+	for _, i := range Commands[command.Name].Secrets {
+		if i < len(args) {
+			args[i] = "[redacted]"
+		}
+	}
+
+	entry := &AuditEntry{
+		Time:   time.Now(),
+		Actor:  data.Nick,
+		Action: command.Name,
+		Args:   args,
+	}
+
+	if message.Client != nil {
+		entry.Addr = addrHost(message.Client.Addr)
+	}
+
+	if len(command.Args) > 0 && slices.Contains(r.Nicks(), command.Args[0]) {
+		entry.Target = command.Args[0]
+	}
+
+	return entry
+}
+
+// commandError works out whether a command failed from the replies it
+// queued: commands report failure with an error to whoever ran them.
+func commandError(client *Client, queued []RoomMessage) (string, bool) {
+	for _, message := range queued {
+		if message.Type == MessageTypeError && message.Target.Type == TargetTypeOne && message.Target.Client == client {
+			return message.Body, true
+		}
+	}
+
+	return "", false
+}
+
+// audit records a command that has run in the room's audit log.
+func (r *Room) audit(entry AuditEntry) {
+	r.auditLog = append(r.auditLog, entry)
+	if len(r.auditLog) > auditSize {
+		r.auditLog = r.auditLog[len(r.auditLog)-auditSize:]
+	}
+}
+
+func (r *Room) showAudit(client *Client, n *string) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	limit := auditDefaultLimit
+	if n != nil {
+		parsed, err := strconv.Atoi(*n)
+		if err != nil || parsed < 1 {
+			reply(MessageTypeError, fmt.Sprintf("invalid number of entries: %s", *n))
+			return
+		}
+		limit = min(parsed, auditSize)
+	}
+
+	entries := r.auditLog[max(len(r.auditLog)-limit, 0):]
+	if len(entries) == 0 {
+		reply(MessageTypeCommand, "the audit log is empty")
+		return
+	}
+
+	lines := make([]string, 0, len(entries)+1)
+	lines = append(lines, fmt.Sprintf("audit log (last %d):", len(entries)))
+	for _, entry := range entries {
+		lines = append(lines, "  "+entry.String())
+	}
+
+	reply(MessageTypeCommand, strings.Join(lines, "\n"))
+}
+
+// apiGetAudit exports a room's audit log as JSON lines, oldest first.
+func apiGetAudit(w http.ResponseWriter, r *http.Request) {
+	var entries []AuditEntry
+	ok := apiRoom(w, r, func(room *Room) {
+		entries = slices.Clone(room.auditLog)
+	})
+
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		_ = enc.Encode(entry)
+	}
+}
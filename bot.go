@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const CommandTargetBot CommandTarget = "bot"
+
+// Bot is an in-process participant in a room. Each attached bot joins its
+// room as a client of its own, so it only sees messages targeted at it.
+// Every attachment runs on a goroutine of its own, not the room's, so a
+// bot attached to several rooms is called concurrently and must guard its
+// own state.
+type Bot interface {
+	// Nick is the nickname the bot joins rooms with.
+	Nick() string
+
+	// Commands lists the slash commands the bot handles. They're added to
+	// the command registry by RegisterBot.
+	Commands() []CommandSpec
+
+	// Handle is called with every message the bot can see, other than its
+	// own.
+	Handle(ctx *BotContext, message RoomMessage)
+
+	// Command is called when someone in the room uses one of the bot's
+	// commands.
+	Command(ctx *BotContext, from string, command *Command)
+}
+
+// BotFactory builds a bot from its config entry.
+type BotFactory func(config BotConfig) (Bot, error)
+
+// RegisterBot adds a bot's commands to the command registry. Like the
+// registry itself, it must only be used before the server starts.
+func RegisterBot(bot Bot) error {
+	for _, spec := range bot.Commands() {
+		if existing, ok := Commands[spec.Name]; ok && existing.Target != CommandTargetBot {
+			return fmt.Errorf("bot %s: command /%s is already registered", bot.Nick(), spec.Name)
+		}
+
+		spec.Target = CommandTargetBot
+		Commands[spec.Name] = spec
+	}
+
+	return nil
+}
+
+// BotContext is a bot's handle on the room it's sitting in. Its methods are
+// safe to call from any goroutine.
+type BotContext struct {
+	Room *Room
+
+	client *Client
+	bot    Bot
+}
+
+func (ctx *BotContext) Nick() string {
+	return ctx.bot.Nick()
+}
+
+// Say posts a message to the whole room.
+func (ctx *BotContext) Say(body string) {
+	ctx.post(ClientMessage{
+		Type:   MessageTypeBot,
+		Client: ctx.client,
+		Body:   body,
+	})
+}
+
+// Whisper sends a message to a single user in the room.
+func (ctx *BotContext) Whisper(nick string, body string) {
+	ctx.post(ClientMessage{
+		Type:   MessageTypeCommand,
+		Client: ctx.client,
+		Command: &Command{
+			Name:    "w",
+			Args:    []string{nick, body},
+			Target:  CommandTargetRoom,
+			OPLevel: OPLevelUser,
+		},
+	})
+}
+
+func (ctx *BotContext) post(message ClientMessage) {
+	select {
+	case ctx.Room.External <- message:
+	case <-ctx.Room.closed:
+	}
+}
+
+// AttachBot joins a bot to a room and runs it until the room closes or the
+// bot is kicked.
+func AttachBot(room *Room, bot Bot) {
+	client := &Client{
+		ID:        "bot:" + bot.Nick(),
+		Addr:      "bot",
+		Connected: time.Now(),
+		Send:      make(chan RoomMessage, 256),
+		Recv:      make(chan ClientMessage, 64),
+		Room:      room,
+		Bot:       bot,
+	}
+
+	ctx := &BotContext{
+		Room:   room,
+		client: client,
+		bot:    bot,
+	}
+
+	room.Register <- RegisterRequest{
+		Client:    client,
+		WantsNick: bot.Nick(),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-room.closed:
+				return
+
+			case message := <-client.Send:
+				if message.Nick == bot.Nick() {
+					continue
+				}
+
+				bot.Handle(ctx, message)
+
+			case message := <-client.Recv:
+				if message.Command == nil {
+					continue
+				}
+
+				// Kicked
+				if message.Command.Name == "exit" {
+					return
+				}
+
+				var from string
+				if message.Identity != nil {
+					from = message.Identity.Nick
+				}
+
+				bot.Command(ctx, from, message.Command)
+			}
+		}
+	}()
+}
+
+// AttachBots attaches every configured bot whose rooms include the room.
+func AttachBots(room *Room) {
+	for _, bot := range bots {
+		if slices.Contains(bot.rooms, room.Name) || slices.Contains(bot.rooms, "*") {
+			AttachBot(room, bot.bot)
+		}
+	}
+}
+
+type configuredBot struct {
+	bot   Bot
+	rooms []string
+}
+
+var bots []configuredBot
+
+// LoadBots builds and registers the bots listed in the config.
+func LoadBots(configs []BotConfig) error {
+	for _, botConfig := range configs {
+		factory, ok := BotTypes[botConfig.Type]
+		if !ok {
+			return fmt.Errorf("unknown bot type: %s", botConfig.Type)
+		}
+
+		bot, err := factory(botConfig)
+		if err != nil {
+			return fmt.Errorf("failed to create %s bot: %w", botConfig.Type, err)
+		}
+
+		if err := RegisterBot(bot); err != nil {
+			return err
+		}
+
+		bots = append(bots, configuredBot{
+			bot:   bot,
+			rooms: botConfig.Rooms,
+		})
+
+		slog.Info("loaded bot", "type", botConfig.Type, "nick", bot.Nick(), "rooms", botConfig.Rooms)
+	}
+
+	return nil
+}
+
+// botCommand hands a bot command to the bot in the room that handles it.
+func (r *Room) botCommand(message ClientMessage, data ClientDataExternal) {
+	for client := range r.Clients {
+		if client.Bot == nil {
+			continue
+		}
+
+		handles := slices.ContainsFunc(client.Bot.Commands(), func(spec CommandSpec) bool {
+			return spec.Name == message.Command.Name
+		})
+		if !handles {
+			continue
+		}
+
+		select {
+		case client.Recv <- ClientMessage{
+			Type:     MessageTypeCommand,
+			Client:   message.Client,
+			Command:  message.Command,
+			Identity: &data,
+		}:
+		default:
+			r.reject(message, fmt.Errorf("%s is busy, try again later", client.Bot.Nick()))
+		}
+		return
+	}
+
+	r.reject(message, fmt.Errorf("no bot in this room handles /%s", message.Command.Name))
+}
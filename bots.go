@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BotTypes are the built-in bots that can be attached by config.
+var BotTypes = map[string]BotFactory{
+	"echo":     NewEchoBot,
+	"reminder": NewReminderBot,
+	"dice":     NewDiceBot,
+	"faq":      NewFAQBot,
+}
+
+func botNick(config BotConfig, fallback string) string {
+	if config.Nick != "" {
+		return config.Nick
+	}
+	return fallback
+}
+
+// EchoBot repeats whatever it's asked to with /echo.
+type EchoBot struct {
+	nick string
+}
+
+func NewEchoBot(config BotConfig) (Bot, error) {
+	return &EchoBot{nick: botNick(config, "echo")}, nil
+}
+
+func (b *EchoBot) Nick() string { return b.nick }
+
+func (b *EchoBot) Commands() []CommandSpec {
+	return []CommandSpec{{
+		Name:    "echo",
+		Desc:    "have the echo bot repeat a message",
+		Help:    "/echo <message>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		OPLevel: OPLevelUser,
+	}}
+}
+
+func (b *EchoBot) Handle(*BotContext, RoomMessage) {}
+
+func (b *EchoBot) Command(ctx *BotContext, _ string, command *Command) {
+	ctx.Say(command.Args[0])
+}
+
+// ReminderBot whispers a reminder back to a user after a delay.
+type ReminderBot struct {
+	nick string
+}
+
+const reminderMax = 24 * time.Hour
+
+func NewReminderBot(config BotConfig) (Bot, error) {
+	return &ReminderBot{nick: botNick(config, "reminder")}, nil
+}
+
+func (b *ReminderBot) Nick() string { return b.nick }
+
+func (b *ReminderBot) Commands() []CommandSpec {
+	return []CommandSpec{{
+		Name:    "remind",
+		Desc:    "get a reminder after a delay",
+		Help:    "/remind <duration> <message>\n  e.g. /remind 10m \"check the build\"",
+		ArgsMin: 2,
+		ArgsMax: 2,
+		OPLevel: OPLevelUser,
+	}}
+}
+
+func (b *ReminderBot) Handle(*BotContext, RoomMessage) {}
+
+func (b *ReminderBot) Command(ctx *BotContext, from string, command *Command) {
+	delay, err := time.ParseDuration(command.Args[0])
+	if err != nil || delay <= 0 || delay > reminderMax {
+		ctx.Whisper(from, fmt.Sprintf("invalid duration %s, must be between 0s and %s", command.Args[0], reminderMax))
+		return
+	}
+
+	ctx.Whisper(from, fmt.Sprintf("ok, I'll remind you in %s", delay))
+
+	text := command.Args[1]
+	time.AfterFunc(delay, func() {
+		ctx.Whisper(from, fmt.Sprintf("reminder: %s", text))
+	})
+}
+
+// DiceBot rolls dice with /roll.
+type DiceBot struct {
+	nick string
+}
+
+const (
+	diceMaxCount = 100
+	diceMaxSides = 1000
+)
+
+func NewDiceBot(config BotConfig) (Bot, error) {
+	return &DiceBot{nick: botNick(config, "dice")}, nil
+}
+
+func (b *DiceBot) Nick() string { return b.nick }
+
+func (b *DiceBot) Commands() []CommandSpec {
+	return []CommandSpec{{
+		Name:    "roll",
+		Desc:    "roll some dice",
+		Help:    "/roll [NdM]\n  e.g. /roll 2d6 (default 1d6)",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		OPLevel: OPLevelUser,
+	}}
+}
+
+func (b *DiceBot) Handle(*BotContext, RoomMessage) {}
+
+func (b *DiceBot) Command(ctx *BotContext, from string, command *Command) {
+	spec := "1d6"
+	if len(command.Args) > 0 {
+		spec = command.Args[0]
+	}
+
+	count, sides, err := ParseDice(spec)
+	if err != nil {
+		ctx.Whisper(from, err.Error())
+		return
+	}
+
+	rolls := make([]string, count)
+	total := 0
+	for i := range rolls {
+		roll := rand.IntN(sides) + 1
+		rolls[i] = strconv.Itoa(roll)
+		total += roll
+	}
+
+	if count == 1 {
+		ctx.Say(fmt.Sprintf("%s rolled %s: %d", from, spec, total))
+	} else {
+		ctx.Say(fmt.Sprintf("%s rolled %s: %s = %d", from, spec, strings.Join(rolls, " + "), total))
+	}
+}
+
+// ParseDice parses dice notation such as 2d6 or d20.
+func ParseDice(spec string) (int, int, error) {
+	countPart, sidesPart, ok := strings.Cut(strings.ToLower(spec), "d")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid dice: %s", spec)
+	}
+
+	count := 1
+	if countPart != "" {
+		n, err := strconv.Atoi(countPart)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid dice: %s", spec)
+		}
+		count = n
+	}
+
+	sides, err := strconv.Atoi(sidesPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid dice: %s", spec)
+	}
+
+	if count < 1 || count > diceMaxCount || sides < 2 || sides > diceMaxSides {
+		return 0, 0, fmt.Errorf("dice must be 1-%d dice of 2-%d sides", diceMaxCount, diceMaxSides)
+	}
+
+	return count, sides, nil
+}
+
+// FAQBot answers messages that mention one of its configured phrases.
+type FAQBot struct {
+	nick string
+	faq  map[string]string
+
+	mu       sync.Mutex
+	answered map[string]time.Time
+}
+
+// faqCooldown stops the bot answering the same phrase repeatedly.
+const faqCooldown = time.Minute
+
+func NewFAQBot(config BotConfig) (Bot, error) {
+	if len(config.FAQ) == 0 {
+		return nil, errors.New("faq bot needs at least one faq entry")
+	}
+
+	faq := make(map[string]string, len(config.FAQ))
+	for phrase, answer := range config.FAQ {
+		faq[strings.ToLower(phrase)] = answer
+	}
+
+	return &FAQBot{
+		nick:     botNick(config, "faq"),
+		faq:      faq,
+		answered: make(map[string]time.Time),
+	}, nil
+}
+
+func (b *FAQBot) Nick() string { return b.nick }
+
+func (b *FAQBot) Commands() []CommandSpec {
+	return []CommandSpec{{
+		Name:    "faq",
+		Desc:    "list the questions the faq bot can answer",
+		Help:    "/faq",
+		ArgsMin: 0,
+		ArgsMax: 0,
+		OPLevel: OPLevelUser,
+	}}
+}
+
+func (b *FAQBot) Handle(ctx *BotContext, message RoomMessage) {
+	if message.Type != MessageTypeMessage {
+		return
+	}
+
+	body := strings.ToLower(message.Body)
+	for phrase, answer := range b.faq {
+		if !strings.Contains(body, phrase) || !b.cooldown(ctx.Room.Name+"\x00"+phrase) {
+			continue
+		}
+
+		ctx.Say(answer)
+		return
+	}
+}
+
+func (b *FAQBot) cooldown(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Since(b.answered[key]) < faqCooldown {
+		return false
+	}
+
+	b.answered[key] = time.Now()
+	return true
+}
+
+func (b *FAQBot) Command(ctx *BotContext, from string, _ *Command) {
+	phrases := make([]string, 0, len(b.faq))
+	for phrase := range b.faq {
+		phrases = append(phrases, phrase)
+	}
+
+	ctx.Whisper(from, fmt.Sprintf("I can answer questions about:\n%s", strings.Join(phrases, "\n")))
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		spec    string
+		count   int
+		sides   int
+		wantErr bool
+	}{
+		{"2d6", 2, 6, false},
+		{"d20", 1, 20, false},
+		{"D8", 1, 8, false},
+		{"100d1000", 100, 1000, false},
+		{"1d1", 0, 0, true},
+		{"0d6", 0, 0, true},
+		{"101d6", 0, 0, true},
+		{"2d1001", 0, 0, true},
+		{"-1d6", 0, 0, true},
+		{"2d", 0, 0, true},
+		{"xd6", 0, 0, true},
+		{"2d6d6", 0, 0, true},
+		{"six", 0, 0, true},
+		{"", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		count, sides, err := ParseDice(test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseDice(%q) error = %v, want error %t", test.spec, err, test.wantErr)
+			continue
+		}
+
+		if count != test.count || sides != test.sides {
+			t.Errorf("ParseDice(%q) = %d, %d, want %d, %d", test.spec, count, sides, test.count, test.sides)
+		}
+	}
+}
@@ -2,67 +2,97 @@ package main
 
 import (
 	"fmt"
-	"github.com/gorilla/websocket"
+	"log/slog"
 	"strings"
 	"sync"
+	"time"
 )
 
 type RegisterRequest struct {
 	Client    *Client
 	WantsNick string
 	Creator   bool
+
+	// Sent is when the request was made, for measuring registration latency
+	Sent time.Time
+
+	// Restored is set when a client follows its room to a replacement it
+	// may already be in
+	Restored bool
 }
 
 type UnregisterRequest struct {
 	Client *Client
 	Reason string
+
+	// Disconnected is set when the client's connection dropped, rather
+	// than it leaving the room
+	Disconnected bool
+}
+
+// Transport carries frames between a client and its connection, whether
+// that's a websocket or an event stream.
+type Transport interface {
+	ReadFrame() (WSFrame, error)
+	WriteMessage(msg RoomMessage) error
+	RemoteAddr() string
+	Close() error
 }
 
 type Client struct {
-	Conn *websocket.Conn
+	ID     string // Session ID, shared across transports
+	Stream string // Page the client serves, among those sharing the session
+	Addr   string
+
+	Connected time.Time
+
+	Transport Transport
 
 	Send chan RoomMessage
 	Recv chan ClientMessage
 
 	Room *Room
 
+	// Bot is set for in-process bot participants
+	Bot Bot
+
 	Data ClientDataInternal
 }
 
-func NewClient(conn *websocket.Conn) *Client {
+func NewClient(id string, transport Transport) *Client {
 	return &Client{
-		Conn: conn,
-		Send: make(chan RoomMessage, 256),
-		Recv: make(chan ClientMessage, 256),
+		ID:        id,
+		Addr:      transport.RemoteAddr(),
+		Connected: time.Now(),
+		Transport: transport,
+		Send:      make(chan RoomMessage, 256),
+		Recv:      make(chan ClientMessage, 256),
 	}
 }
 
 func (c *Client) readPump() {
 	defer func() {
-		_ = c.Conn.Close()
+		_ = c.Transport.Close()
 	}()
 
 	for {
-		var frame WSFrame
-		if err := c.Conn.ReadJSON(&frame); err != nil {
+		frame, err := c.Transport.ReadFrame()
+		if err != nil {
 			break
 		}
 
-		if strings.TrimSpace(frame.Message) == "" {
+		message, ok := frame.ClientMessage(c)
+		if !ok {
 			continue
 		}
 
-		c.Recv <- ClientMessage{
-			Type:   MessageTypeMessage,
-			Client: c,
-			Body:   frame.Message,
-		}
+		c.Recv <- message
 	}
 }
 
 func (c *Client) writePump() {
 	for msg := range c.Send {
-		err := c.Conn.WriteMessage(websocket.TextMessage, []byte(msg.Render()))
+		err := c.Transport.WriteMessage(msg)
 		if err != nil {
 			break
 		}
@@ -104,6 +134,7 @@ func (c *Client) start(roomName string, password *string) {
 	}
 	Rooms.Set(roomName, room)
 	go room.Run()
+	AttachBots(room)
 
 	// Join
 	c.Room = room
@@ -111,6 +142,7 @@ func (c *Client) start(roomName string, password *string) {
 		Client:    c,
 		WantsNick: c.Data.Nick,
 		Creator:   true,
+		Sent:      time.Now(),
 	}
 }
 
@@ -141,6 +173,11 @@ func (c *Client) join(roomName string, password *string) {
 		}
 	}
 
+	c.enter(room, roomName)
+}
+
+// enter moves the client into a room it's been let into.
+func (c *Client) enter(room *Room, roomName string) {
 	// Exit current room
 	if c.Room != nil {
 		c.Room.Unregister <- UnregisterRequest{
@@ -163,10 +200,16 @@ func (c *Client) join(roomName string, password *string) {
 	room.Register <- RegisterRequest{
 		Client:    c,
 		WantsNick: c.Data.Nick,
+		Sent:      time.Now(),
 	}
 }
 
 func (c *Client) command(command *Command) {
+	// nick is counted by the room it's passed on to
+	if command.Name != "nick" {
+		Metrics.Commands.Inc(command.Name)
+	}
+
 	switch command.Name {
 	case "join":
 		var password *string
@@ -184,7 +227,10 @@ func (c *Client) command(command *Command) {
 
 		c.start(command.Args[0], password)
 	case "exit":
-		c.join("main", nil)
+		if lobby, ok := Rooms.Get(DefaultRoom); ok && c.Room == lobby {
+			return
+		}
+		c.join(DefaultRoom, nil)
 
 	case "clear":
 		c.Send <- RoomMessage{
@@ -201,6 +247,9 @@ func (c *Client) command(command *Command) {
 			}
 		}
 
+	case "sa":
+		c.serverAdmin(command.Args)
+
 	case "help":
 		c.Send <- RoomMessage{
 			Type: MessageTypeCommand,
@@ -219,6 +268,19 @@ func (c *Client) handle(done chan struct{}) {
 		case <-done:
 			return
 		case msg := <-c.Recv:
+			c.follow()
+
+			// Commands issued on the client's behalf, e.g. by a kick
+			if msg.Type == MessageTypeCommand && msg.Command != nil {
+				c.command(msg.Command)
+				continue
+			}
+
+			if msg.Type == MessageTypeTyping {
+				c.Room.External <- msg
+				continue
+			}
+
 			command, err := ParseCommand(msg.Body)
 			if command == nil {
 				c.Room.External <- msg
@@ -226,6 +288,10 @@ func (c *Client) handle(done chan struct{}) {
 			}
 
 			if err != nil {
+				Metrics.Errors.Inc("command")
+				// Only log the name, since arguments can be passwords
+				name, _, _ := strings.Cut(strings.TrimSpace(msg.Body), " ")
+				slog.Info("command failed", clientAttr(c, c.Data.Nick), "command", strings.TrimPrefix(name, "/"), "error", err)
 				c.Send <- RoomMessage{
 					Type: MessageTypeError,
 					Body: err.Error(),
@@ -233,7 +299,7 @@ func (c *Client) handle(done chan struct{}) {
 				continue
 			}
 
-			if command.Target == CommandTargetRoom {
+			if command.Target == CommandTargetRoom || command.Target == CommandTargetBot {
 				c.Room.External <- ClientMessage{
 					Type:    MessageTypeCommand,
 					Client:  c,
@@ -250,14 +316,34 @@ func (c *Client) Serve() {
 	done := make(chan struct{})
 	var once sync.Once
 
-	c.join(DefaultRoom, nil)
+	Sessions.Set(streamKey(c.ID, c.Stream), c)
+	slog.Info("client connected", clientAttr(c, ""))
+
+	point, ok := Resumes.Take(c.ID)
+	if ok {
+		c.Data.Nick = point.Nick
+	}
+
+	if ok && point.Room != nil {
+		c.enter(point.Room, point.Name)
+	} else {
+		c.join(DefaultRoom, nil)
+	}
 
 	closer := func() {
 		once.Do(func() {
+			key := streamKey(c.ID, c.Stream)
+			Sessions.Mu.Lock()
+			if Sessions.M[key] == c {
+				delete(Sessions.M, key)
+			}
+			Sessions.Mu.Unlock()
+
 			if c.Room != nil {
 				c.Room.Unregister <- UnregisterRequest{
-					Client: c,
-					Reason: "quit",
+					Client:       c,
+					Reason:       "quit",
+					Disconnected: true,
 				}
 			}
 			close(c.Send)
@@ -270,10 +356,22 @@ func (c *Client) Serve() {
 		closer()
 	}()
 
+	written := make(chan struct{})
 	go func() {
 		c.writePump()
 		closer()
+		close(written)
 	}()
 
 	c.handle(done)
+
+	if c.Room != nil {
+		Resumes.Save(c.ID, c.Room, c.Data.Nick)
+	}
+
+	// Streaming transports write through the handler's ResponseWriter, so
+	// don't return until the writer has finished with it.
+	<-written
+
+	slog.Info("client disconnected", clientAttr(c, ""), "duration", time.Since(c.Connected))
 }
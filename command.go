@@ -54,6 +54,13 @@ type CommandSpec struct {
 	ArgsMin, ArgsMax int
 	Target           CommandTarget
 	OPLevel          OPLevel
+
+	// Rest makes the last argument take the remainder of the input
+	// verbatim, so free text doesn't need quoting.
+	Rest bool
+
+	// Secrets lists the arguments, by index, redacted from the audit log
+	Secrets []int
 }
 
 type Command struct {
@@ -111,13 +118,32 @@ var Commands = map[string]CommandSpec{
 	},
 	"w": {
 		Name:    "w",
-		Desc:    "send a direct message to a user",
+		Desc:    "whisper to a user in this room",
 		Help:    "/w <nickname> <message>",
 		ArgsMin: 2,
 		ArgsMax: 2,
 		Target:  CommandTargetRoom,
 		OPLevel: OPLevelUser,
 	},
+	"msg": {
+		Name:    "msg",
+		Desc:    "send a direct message to a user in any room",
+		Help:    "/msg <nickname> <message>",
+		ArgsMin: 2,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+		Rest:    true,
+	},
+	"dms": {
+		Name:    "dms",
+		Desc:    "list your direct message conversations, or show one",
+		Help:    "/dms [nickname]",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
 	"clear": {
 		Name:    "clear",
 		Desc:    "clear the chat window",
@@ -126,6 +152,16 @@ var Commands = map[string]CommandSpec{
 		ArgsMax: 0,
 		OPLevel: OPLevelNone,
 	},
+	"sa": {
+		Name:    "sa",
+		Desc:    "server administration",
+		Help:    "/sa login <token> | logout | rooms | clients [room] | close <room> [reason] | rename <room> <name>\n  /sa broadcast <message> | kill <room> <nick> | ban <address|room nick> [reason] | unban <address> | bans\n  /sa trace <room> [on|off]",
+		ArgsMin: 1,
+		ArgsMax: 2,
+		Target:  CommandTargetClient,
+		OPLevel: OPLevelNone,
+		Rest:    true,
+	},
 	"help": {
 		Name:    "help",
 		Desc:    "list all commands, or get help for a specific command",
@@ -135,6 +171,60 @@ var Commands = map[string]CommandSpec{
 		Target:  CommandTargetClient,
 		OPLevel: OPLevelNone,
 	},
+	"ignore": {
+		Name:    "ignore",
+		Desc:    "hide messages from a user, optionally by their address too",
+		Help:    "/ignore <nickname> [--addr]",
+		ArgsMin: 1,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"unignore": {
+		Name:    "unignore",
+		Desc:    "stop ignoring a user",
+		Help:    "/unignore <nickname>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"ignores": {
+		Name:    "ignores",
+		Desc:    "list the users you are ignoring",
+		Help:    "/ignores",
+		ArgsMin: 0,
+		ArgsMax: 0,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"filter": {
+		Name:    "filter",
+		Desc:    "manage the room's message filters",
+		Help:    "/filter add <word|regex|links|caps|repeat> [pattern] [--action mask|reject|warn|mute] [--hits n]\n  /filter remove <id>\n  /filter list",
+		ArgsMin: 1,
+		ArgsMax: 7,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+	"mute": {
+		Name:    "mute",
+		Desc:    "stop a user posting for a while",
+		Help:    "/mute <nickname> [duration]",
+		ArgsMin: 1,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+	"unmute": {
+		Name:    "unmute",
+		Desc:    "let a muted user post again",
+		Help:    "/unmute <nickname>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
 	"op": {
 		Name:    "op",
 		Desc:    "change permission levels",
@@ -153,6 +243,194 @@ var Commands = map[string]CommandSpec{
 		Target:  CommandTargetRoom,
 		OPLevel: OPLevelAdmin,
 	},
+	"topic": {
+		Name:    "topic",
+		Desc:    "show or change the room topic",
+		Help:    "/topic [topic]",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"me": {
+		Name:    "me",
+		Desc:    "describe an action, e.g. /me waves",
+		Help:    "/me <action>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+		Rest:    true,
+	},
+	"shrug": {
+		Name:    "shrug",
+		Desc:    "send a message with a shrug appended",
+		Help:    "/shrug [message]",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+		Rest:    true,
+	},
+	"tableflip": {
+		Name:    "tableflip",
+		Desc:    "send a message with a table flip appended",
+		Help:    "/tableflip [message]",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+		Rest:    true,
+	},
+	"unflip": {
+		Name:    "unflip",
+		Desc:    "send a message with the table put back appended",
+		Help:    "/unflip [message]",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+		Rest:    true,
+	},
+	"notice": {
+		Name:    "notice",
+		Desc:    "send a highlighted notice to the room",
+		Help:    "/notice <message>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+		Rest:    true,
+	},
+	"reply": {
+		Name:    "reply",
+		Desc:    "reply to a message",
+		Help:    "/reply <message id> <message>",
+		ArgsMin: 2,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+		Rest:    true,
+	},
+	"thread": {
+		Name:    "thread",
+		Desc:    "show a message and all replies to it",
+		Help:    "/thread <message id>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"react": {
+		Name:    "react",
+		Desc:    "react to a message with an emoji",
+		Help:    "/react <message id> <emoji>",
+		ArgsMin: 2,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"unreact": {
+		Name:    "unreact",
+		Desc:    "remove your reaction from a message",
+		Help:    "/unreact <message id> <emoji>",
+		ArgsMin: 2,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"seen": {
+		Name:    "seen",
+		Desc:    "show when a user last spoke or was sent a message in the room",
+		Help:    "/seen <nick>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"poll": {
+		Name:    "poll",
+		Desc:    "start or close a poll",
+		Help:    "/poll \"question\" \"option\" \"option\"... [--duration 10m] [--anonymous]\n  /poll close [poll id]",
+		ArgsMin: 1,
+		ArgsMax: 1 + pollMaxOptions + 3, // Question, options and flags
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"vote": {
+		Name:    "vote",
+		Desc:    "vote in a poll",
+		Help:    "/vote <poll id> <option number or text>",
+		ArgsMin: 2,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"pin": {
+		Name:    "pin",
+		Desc:    "pin a message to the room header",
+		Help:    "/pin <message id>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+	"unpin": {
+		Name:    "unpin",
+		Desc:    "unpin a message",
+		Help:    "/unpin <message id>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+	"pins": {
+		Name:    "pins",
+		Desc:    "list pinned messages",
+		Help:    "/pins",
+		ArgsMin: 0,
+		ArgsMax: 0,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+	},
+	"kick": {
+		Name:    "kick",
+		Desc:    "remove a user from the room",
+		Help:    "/kick <nick> [reason]",
+		ArgsMin: 1,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+	"webhook": {
+		Name:    "webhook",
+		Desc:    "manage outgoing webhooks for room events",
+		Help:    "/webhook add <url> [events] | remove <id> | list | log <id>\n  events: comma-separated from message,join,leave,nick,kick,topic (default all)",
+		ArgsMin: 1,
+		ArgsMax: 3,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+		// Webhook URLs often carry a token that lets anyone post to them
+		Secrets: []int{1},
+	},
+	"hook": {
+		Name:    "hook",
+		Desc:    "manage incoming webhooks that post into the room",
+		Help:    "/hook create <name> [nick] [color] | delete <name> | list",
+		ArgsMin: 1,
+		ArgsMax: 4,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+	"formatting": {
+		Name:    "formatting",
+		Desc:    "turn message formatting on or off for the room",
+		Help:    "/formatting <on|off>",
+		ArgsMin: 1,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
 	"password": {
 		Name:    "password",
 		Desc:    "set or clear the password for the room",
@@ -161,7 +439,53 @@ var Commands = map[string]CommandSpec{
 		ArgsMax: 1,
 		Target:  CommandTargetRoom,
 		OPLevel: OPLevelAdmin,
+		Secrets: []int{0},
 	},
+	"report": {
+		Name:    "report",
+		Desc:    "report a user or message to the room's moderators",
+		Help:    "/report <nickname|message id> <reason>",
+		ArgsMin: 2,
+		ArgsMax: 2,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelUser,
+		Rest:    true,
+	},
+	"reports": {
+		Name:    "reports",
+		Desc:    "review reports from users",
+		Help:    "/reports [all]",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+	"resolve": {
+		Name:    "resolve",
+		Desc:    "resolve a report, optionally acting on it",
+		Help:    "/resolve <report id> [dismiss|mute|kick] [note]",
+		ArgsMin: 1,
+		ArgsMax: 3,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+		Rest:    true,
+	},
+	"audit": {
+		Name:    "audit",
+		Desc:    "show the room's moderation log",
+		Help:    "/audit [n]",
+		ArgsMin: 0,
+		ArgsMax: 1,
+		Target:  CommandTargetRoom,
+		OPLevel: OPLevelAdmin,
+	},
+}
+
+// textFaces are appended to messages by the command of the same name.
+var textFaces = map[string]string{
+	"shrug":     `¯\_(ツ)_/¯`,
+	"tableflip": "(╯°□°)╯︵ ┻━┻",
+	"unflip":    "┬─┬ノ( º _ ºノ)",
 }
 
 func ParseCommand(input string) (*Command, error) {
@@ -170,19 +494,30 @@ func ParseCommand(input string) (*Command, error) {
 		return nil, nil
 	}
 
-	tokens, err := shlex.Split(input)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse command: %w", err)
+	head, tail, _ := strings.Cut(input, " ")
+	name := strings.TrimPrefix(head, "/")
+
+	spec, ok := Commands[name]
+
+	var args []string
+	if ok && spec.Rest {
+		args = splitRest(tail, spec.ArgsMax)
+	} else {
+		tokens, err := shlex.Split(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse command: %w", err)
+		}
+
+		name = strings.TrimPrefix(tokens[0], "/")
+		args = tokens[1:]
+		spec, ok = Commands[name]
 	}
 
-	name := strings.TrimPrefix(tokens[0], "/")
-	args := tokens[1:]
 	command := &Command{
 		Name: name,
 		Args: args,
 	}
 
-	spec, ok := Commands[name]
 	if !ok {
 		return command, fmt.Errorf("unknown command: %s", name)
 	}
@@ -203,6 +538,29 @@ func ParseCommand(input string) (*Command, error) {
 	}, nil
 }
 
+// splitRest splits input into at most n arguments on whitespace, the last
+// taking whatever remains.
+func splitRest(input string, n int) []string {
+	args := make([]string, 0, n)
+
+	for len(args) < n-1 {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			return args
+		}
+
+		arg, rest, _ := strings.Cut(input, " ")
+		args = append(args, arg)
+		input = rest
+	}
+
+	if input = strings.TrimSpace(input); input != "" {
+		args = append(args, input)
+	}
+
+	return args
+}
+
 func Help(args []string) string {
 	if len(args) == 0 {
 		commands := make([]string, 0, len(Commands))
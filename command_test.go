@@ -0,0 +1,29 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitRest(t *testing.T) {
+	tests := []struct {
+		input string
+		n     int
+		want  []string
+	}{
+		{"bob hello there", 2, []string{"bob", "hello there"}},
+		{"  bob   hello  there ", 2, []string{"bob", "hello  there"}},
+		{"msg-1 first line\nsecond line", 2, []string{"msg-1", "first line\nsecond line"}},
+		{"a b c d", 3, []string{"a", "b", "c d"}},
+		{"bob", 2, []string{"bob"}},
+		{"", 2, []string{}},
+		{"   ", 2, []string{}},
+		{"the whole thing", 1, []string{"the whole thing"}},
+	}
+
+	for _, test := range tests {
+		if got := splitRest(test.input, test.n); !slices.Equal(got, test.want) {
+			t.Errorf("splitRest(%q, %d) = %q, want %q", test.input, test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type Config struct {
+	Addr string `json:"addr"`
+
+	// PublicURL prefixes links the server hands out, such as hook URLs.
+	PublicURL string `json:"public_url"`
+
+	APIKeys []APIKey `json:"api_keys"`
+
+	// AdminToken lets users become server admins with /sa login. Server
+	// admin is disabled when it's empty.
+	AdminToken string `json:"admin_token"`
+
+	// AllowPrivateWebhooks lets webhooks reach loopback and private
+	// addresses, e.g. for local testing.
+	AllowPrivateWebhooks bool `json:"allow_private_webhooks"`
+
+	Bots []BotConfig `json:"bots"`
+
+	Uploads UploadConfig `json:"uploads"`
+
+	Log LogConfig `json:"log"`
+
+	Watchdog WatchdogConfig `json:"watchdog"`
+}
+
+type UploadConfig struct {
+	Dir       string `json:"dir"`
+	MaxSize   int64  `json:"max_size"`
+	RoomQuota int64  `json:"room_quota"`
+
+	// AllowedTypes lists MIME types that can be uploaded, where "image/*"
+	// matches any image type.
+	AllowedTypes []string `json:"allowed_types"`
+}
+
+func (c UploadConfig) Allows(contentType string) bool {
+	for _, allowed := range c.AllowedTypes {
+		if allowed == contentType {
+			return true
+		}
+
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BotConfig attaches a built-in bot to rooms by name, or to every room
+// with "*". Rooms that don't exist yet get the bot when they're started.
+type BotConfig struct {
+	Type  string   `json:"type"`
+	Nick  string   `json:"nick"`
+	Rooms []string `json:"rooms"`
+
+	// FAQ maps phrases to the answers the faq bot responds with.
+	FAQ map[string]string `json:"faq"`
+}
+
+// APIKey grants HTTP API access, posting messages under a bot identity.
+// Admin keys can also change room rules and use the server admin API.
+type APIKey struct {
+	Key   string `json:"key"`
+	Nick  string `json:"nick"`
+	Color string `json:"color"`
+	Admin bool   `json:"admin"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr: ":8080",
+		Uploads: UploadConfig{
+			Dir:          "uploads",
+			MaxSize:      10 << 20,
+			RoomQuota:    100 << 20,
+			AllowedTypes: []string{"image/png", "image/jpeg", "image/gif", "image/webp", "text/plain", "application/pdf"},
+		},
+	}
+}
+
+// LoadConfig reads a JSON config file over the defaults. An empty path
+// returns the defaults unchanged.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	return config, nil
+}
+
+func (c *Config) APIKey(key string) (APIKey, bool) {
+	for _, k := range c.APIKeys {
+		if k.Key != "" && subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return k, true
+		}
+	}
+
+	return APIKey{}, false
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DashboardRoutes serves the admin dashboard at /admin.
+func DashboardRoutes(r chi.Router) {
+	r.Use(dashboardAuth)
+
+	r.Get("/", dashboardHandler)
+	r.Get("/rooms", dashboardRoomsHandler)
+
+	r.Group(func(r chi.Router) {
+		r.Use(dashboardHTMX)
+		r.Post("/rooms/{name}/kick", dashboardKickHandler)
+		r.Post("/rooms/{name}/close", dashboardCloseHandler)
+		r.Post("/rooms/{name}/welcome", dashboardWelcomeHandler)
+		r.Post("/ban", dashboardBanHandler)
+	})
+}
+
+// dashboardAuth lets in sessions logged in with /sa login, or requests
+// with the admin token as their basic auth password. The dashboard is off
+// when no admin token is configured.
+func dashboardAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if id, ok := sessionID(r); ok {
+			if admin, _ := ServerAdmins.Get(id); admin {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		_, password, ok := r.BasicAuth()
+		if ok && subtle.ConstantTimeCompare([]byte(config.AdminToken), []byte(password)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="e74chat admin"`)
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+	})
+}
+
+// dashboardHTMX only accepts actions sent by htmx. Browsers won't add the
+// header to cross-site form posts, so this keeps other sites from acting
+// with an admin's credentials.
+func dashboardHTMX(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("HX-Request") != "true" {
+			http.Error(w, "Bad request.", http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type DashboardData struct {
+	Rooms []AdminRoom
+	Bans  map[string]string
+	Error string
+}
+
+func dashboardData(err error) DashboardData {
+	data := DashboardData{
+		Rooms: AdminRooms(),
+		Bans:  make(map[string]string),
+	}
+
+	Bans.Mu.RLock()
+	for addr, reason := range Bans.M {
+		data.Bans[addr] = reason
+	}
+	Bans.Mu.RUnlock()
+
+	if err != nil {
+		data.Error = err.Error()
+	}
+
+	return data
+}
+
+func dashboardHandler(w http.ResponseWriter, _ *http.Request) {
+	execute(w, "admin.tmpl", dashboardData(nil))
+}
+
+func dashboardRoomsHandler(w http.ResponseWriter, _ *http.Request) {
+	execute(w, "admin-rooms", dashboardData(nil))
+}
+
+func dashboardKickHandler(w http.ResponseWriter, r *http.Request) {
+	nick := r.FormValue("nick")
+	reason := strings.TrimSpace(r.FormValue("reason"))
+
+	err := adminErrNoRoom
+	if room, ok := Rooms.Get(roomParam(r)); ok {
+		room.Query(func(room *Room) {
+			err = room.adminKick(nick, reason)
+		})
+	}
+
+	execute(w, "admin-rooms", dashboardData(err))
+}
+
+func dashboardCloseHandler(w http.ResponseWriter, r *http.Request) {
+	err := CloseRoom(roomParam(r), strings.TrimSpace(r.FormValue("reason")))
+	execute(w, "admin-rooms", dashboardData(err))
+}
+
+func dashboardWelcomeHandler(w http.ResponseWriter, r *http.Request) {
+	welcome := strings.TrimSpace(r.FormValue("welcome"))
+
+	var err error
+	room, ok := Rooms.Get(roomParam(r))
+	if !ok || !room.Query(func(room *Room) {
+		room.Rules.Apply(RulesUpdate{WelcomeMessage: &welcome})
+	}) {
+		err = adminErrNoRoom
+	}
+
+	execute(w, "admin-rooms", dashboardData(err))
+}
+
+func dashboardBanHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if target := strings.TrimSpace(r.FormValue("target")); target == "" {
+		err = errors.New("nothing to ban")
+	} else if err = checkOwnBan(target, r.RemoteAddr); err == nil {
+		_, err = Ban(target, strings.TrimSpace(r.FormValue("reason")))
+	}
+
+	execute(w, "admin-rooms", dashboardData(err))
+}
+
+// adminKick kicks a user on behalf of a server admin. It runs inside a
+// query, so it marks the room to stop if that leaves it empty.
+func (r *Room) adminKick(nick string, reason string) error {
+	if !slices.Contains(r.Nicks(), nick) {
+		return adminErrNotOnline
+	}
+
+	err := r.kick(nil, ClientDataExternal{Nick: "a server admin"}, nick, reason)
+	if r.shouldQuit(err) {
+		r.closing = true
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const dmHistorySize = 200
+
+// directoryEntry is a client that holds a nick in some room.
+type directoryEntry struct {
+	Client *Client
+	Room   *Room
+}
+
+// directory resolves nicks server-wide. Nicks are only unique within a
+// room, so a nick can map to several clients; the most recent wins.
+type directory struct {
+	mu    sync.RWMutex
+	nicks map[string][]directoryEntry
+}
+
+var Directory = &directory{
+	nicks: make(map[string][]directoryEntry),
+}
+
+func (d *directory) Add(nick string, client *Client, room *Room) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nicks[nick] = append(d.nicks[nick], directoryEntry{Client: client, Room: room})
+}
+
+func (d *directory) Remove(nick string, client *Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries := slices.DeleteFunc(d.nicks[nick], func(entry directoryEntry) bool {
+		return entry.Client == client
+	})
+
+	if len(entries) == 0 {
+		delete(d.nicks, nick)
+	} else {
+		d.nicks[nick] = entries
+	}
+}
+
+func (d *directory) Lookup(nick string) (directoryEntry, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	entries := d.nicks[nick]
+	if len(entries) == 0 {
+		return directoryEntry{}, false
+	}
+
+	return entries[len(entries)-1], true
+}
+
+// LookupSession, unlike Lookup, prefers the entry for a particular session, so
+// a conversation stays with the person it was started with when someone
+// else takes their nick.
+func (d *directory) LookupSession(nick string, id string) (directoryEntry, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	entries := d.nicks[nick]
+	if len(entries) == 0 {
+		return directoryEntry{}, false
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Client.ID == id {
+			return entries[i], true
+		}
+	}
+
+	return entries[len(entries)-1], true
+}
+
+// dmStore keeps the history of each direct message conversation, keyed by
+// the session IDs of the pair in it. Nicks can be taken by anyone, so
+// they're only kept for showing who a conversation is with.
+type dmStore struct {
+	mu            sync.Mutex
+	conversations map[[2]string]*dmConversation
+}
+
+type dmConversation struct {
+	// nicks holds the latest nick of each session in the conversation
+	nicks    map[string]string
+	messages []RoomMessage
+}
+
+var DMs = &dmStore{
+	conversations: make(map[[2]string]*dmConversation),
+}
+
+func dmKey(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// Record adds a message sent from one session to another to their
+// conversation.
+func (s *dmStore) Record(from string, to string, message RoomMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := dmKey(from, to)
+	conversation, ok := s.conversations[key]
+	if !ok {
+		conversation = &dmConversation{nicks: make(map[string]string, 2)}
+		s.conversations[key] = conversation
+	}
+
+	conversation.nicks[from] = message.Nick
+	conversation.nicks[to] = message.To
+
+	conversation.messages = append(conversation.messages, message)
+	if len(conversation.messages) > dmHistorySize {
+		conversation.messages = conversation.messages[len(conversation.messages)-dmHistorySize:]
+	}
+}
+
+// Partner returns the session that id most recently talked to as nick.
+func (s *dmStore) Partner(id string, nick string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var partner string
+	var last RoomMessage
+	for key, conversation := range s.conversations {
+		other, ok := dmOther(key, id)
+		if !ok || conversation.nicks[other] != nick {
+			continue
+		}
+
+		latest := conversation.messages[len(conversation.messages)-1]
+		if partner == "" || latest.Time.After(last.Time) {
+			partner, last = other, latest
+		}
+	}
+
+	return partner, partner != ""
+}
+
+func (s *dmStore) History(a, b string) []RoomMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversation, ok := s.conversations[dmKey(a, b)]
+	if !ok {
+		return nil
+	}
+
+	return slices.Clone(conversation.messages)
+}
+
+// dmOther returns the other session in a conversation key, if id is in it.
+func dmOther(key [2]string, id string) (string, bool) {
+	switch id {
+	case key[0]:
+		return key[1], true
+	case key[1]:
+		return key[0], true
+	default:
+		return "", false
+	}
+}
+
+// Conversation summarises a direct message conversation for /dms.
+type Conversation struct {
+	With     string
+	Messages int
+	Last     RoomMessage
+}
+
+func (s *dmStore) Conversations(id string) []Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var conversations []Conversation
+	for key, conversation := range s.conversations {
+		other, ok := dmOther(key, id)
+		if !ok || len(conversation.messages) == 0 {
+			continue
+		}
+
+		conversations = append(conversations, Conversation{
+			With:     conversation.nicks[other],
+			Messages: len(conversation.messages),
+			Last:     conversation.messages[len(conversation.messages)-1],
+		})
+	}
+
+	slices.SortFunc(conversations, func(a, b Conversation) int {
+		return b.Last.Time.Compare(a.Last.Time)
+	})
+
+	return conversations
+}
+
+// directMessage sends a private message to a nick in any room, echoing it
+// back to the sender.
+func (r *Room) directMessage(client *Client, data ClientDataExternal, nick string, body string) {
+	fail := func(body string) {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	if data.Nick == "" {
+		fail(roomErrNoNick.Error())
+		return
+	}
+
+	if nick == data.Nick {
+		fail("you can't message yourself")
+		return
+	}
+
+	partner, _ := DMs.Partner(client.ID, nick)
+	entry, ok := Directory.LookupSession(nick, partner)
+	if !ok {
+		fail(fmt.Sprintf("%s is not online", nick))
+		return
+	}
+
+	if entry.Client.ID == client.ID {
+		fail("you can't message yourself")
+		return
+	}
+
+	message := RoomMessage{
+		Type:  MessageTypeDM,
+		Nick:  data.Nick,
+		Color: data.Color,
+		Body:  body,
+		To:    nick,
+		From:  NewSender(client, data),
+	}.Fill()
+
+	delivered := message
+	delivered.Target = Target{
+		Type:   TargetTypeOne,
+		Client: entry.Client,
+	}
+
+	select {
+	case entry.Room.Internal <- delivered:
+	default:
+		fail(fmt.Sprintf("couldn't deliver your message to %s", nick))
+		return
+	}
+
+	DMs.Record(client.ID, entry.Client.ID, message)
+
+	message.Target = Target{
+		Type:   TargetTypeOne,
+		Client: client,
+	}
+	r.enqueue(message)
+}
+
+// dms lists the client's conversations, or replays one into their DM pane.
+func (r *Room) dms(client *Client, data ClientDataExternal, with *string) error {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	if data.Nick == "" {
+		reply(MessageTypeError, roomErrNoNick.Error())
+		return nil
+	}
+
+	if with != nil {
+		var history []RoomMessage
+		if partner, ok := DMs.Partner(client.ID, *with); ok {
+			history = slices.DeleteFunc(DMs.History(client.ID, partner), func(message RoomMessage) bool {
+				return Ignores.Ignoring(client.ID, message)
+			})
+		}
+		if len(history) == 0 {
+			reply(MessageTypeError, fmt.Sprintf("no messages with %s", *with))
+			return nil
+		}
+
+		for _, message := range history {
+			message.Target = Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			}
+			if err := r.send(client, message); err != nil {
+				return err
+			}
+
+			// Stop if the client was evicted for falling behind
+			if _, ok := r.Clients[client]; !ok {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	conversations := DMs.Conversations(client.ID)
+	if len(conversations) == 0 {
+		reply(MessageTypeCommand, "no direct messages")
+		return nil
+	}
+
+	lines := make([]string, 0, len(conversations)+1)
+	lines = append(lines, "direct messages:")
+	for _, conversation := range conversations {
+		lines = append(lines, fmt.Sprintf("  %s: %d messages, last at %s", conversation.With, conversation.Messages, conversation.Last.Time.Format("15:04:05")))
+	}
+
+	reply(MessageTypeCommand, strings.Join(lines, "\n"))
+	return nil
+}
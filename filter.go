@@ -0,0 +1,465 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type FilterKind string
+
+const (
+	FilterKindWord   FilterKind = "word"
+	FilterKindRegex  FilterKind = "regex"
+	FilterKindLinks  FilterKind = "links"
+	FilterKindCaps   FilterKind = "caps"
+	FilterKindRepeat FilterKind = "repeat"
+)
+
+type FilterAction string
+
+const (
+	FilterActionMask   FilterAction = "mask"
+	FilterActionReject FilterAction = "reject"
+	FilterActionWarn   FilterAction = "warn"
+	FilterActionMute   FilterAction = "mute"
+)
+
+const (
+	filterMaxPattern   = 200
+	filterDefaultHits  = 3
+	filterMuteDuration = 5 * time.Minute
+
+	// Messages are shouting when at least capsRatio of at least capsMinLetters
+	// letters are upper case
+	capsMinLetters = 10
+	capsRatio      = 0.7
+
+	// repeatRun is how many of the same character in a row count as spam
+	repeatRun = 6
+)
+
+var (
+	roomErrMuted    = errors.New("you are muted")
+	roomErrFiltered = errors.New("your message was blocked by the room's filters")
+
+	linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+)
+
+// Filter is a moderation rule checked against every message posted to a
+// room. Mute filters reject the message and mute the sender after Hits
+// matches.
+type Filter struct {
+	ID      int          `json:"id"`
+	Kind    FilterKind   `json:"kind"`
+	Pattern string       `json:"pattern,omitempty"`
+	Action  FilterAction `json:"action"`
+	Hits    int          `json:"hits,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// ParseFilter parses the arguments to /filter add.
+func ParseFilter(args []string) (Filter, error) {
+	filter := Filter{Action: FilterActionReject}
+
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--action", "--hits":
+			if i+1 >= len(args) {
+				return filter, fmt.Errorf("missing value for %s", args[i])
+			}
+
+			if args[i] == "--action" {
+				filter.Action = FilterAction(args[i+1])
+			} else {
+				hits, err := strconv.Atoi(args[i+1])
+				if err != nil || hits < 1 {
+					return filter, fmt.Errorf("invalid number of hits: %s", args[i+1])
+				}
+				filter.Hits = hits
+			}
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	if len(rest) == 0 {
+		return filter, fmt.Errorf("missing filter kind")
+	}
+
+	filter.Kind = FilterKind(rest[0])
+	switch filter.Kind {
+	case FilterKindWord, FilterKindRegex:
+		if len(rest) != 2 || rest[1] == "" {
+			return filter, fmt.Errorf("%s filters need a pattern", filter.Kind)
+		}
+		filter.Pattern = rest[1]
+	case FilterKindLinks, FilterKindCaps, FilterKindRepeat:
+		if len(rest) != 1 {
+			return filter, fmt.Errorf("%s filters don't take a pattern", filter.Kind)
+		}
+	default:
+		return filter, fmt.Errorf("unknown filter kind: %s", filter.Kind)
+	}
+
+	switch filter.Action {
+	case FilterActionMask, FilterActionReject, FilterActionWarn:
+		if filter.Hits != 0 {
+			return filter, fmt.Errorf("--hits only applies to mute filters")
+		}
+	case FilterActionMute:
+		if filter.Hits == 0 {
+			filter.Hits = filterDefaultHits
+		}
+	default:
+		return filter, fmt.Errorf("unknown filter action: %s", filter.Action)
+	}
+
+	if len(filter.Pattern) > filterMaxPattern {
+		return filter, fmt.Errorf("filter patterns can be at most %d characters", filterMaxPattern)
+	}
+
+	if err := filter.compile(); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+func (f *Filter) compile() error {
+	var err error
+	switch f.Kind {
+	case FilterKindWord:
+		f.re, err = regexp.Compile(`(?i)\b` + regexp.QuoteMeta(f.Pattern) + `\b`)
+	case FilterKindRegex:
+		f.re, err = regexp.Compile(f.Pattern)
+	case FilterKindLinks:
+		f.re = linkPattern
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	return nil
+}
+
+func (f Filter) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d: %s", f.ID, f.Kind)
+	if f.Pattern != "" {
+		fmt.Fprintf(&b, " %q", f.Pattern)
+	}
+	fmt.Fprintf(&b, " → %s", f.Action)
+	if f.Action == FilterActionMute {
+		fmt.Fprintf(&b, " after %d hits", f.Hits)
+	}
+	return b.String()
+}
+
+func (f Filter) Match(body string) bool {
+	switch f.Kind {
+	case FilterKindCaps:
+		return shouting(body)
+	case FilterKindRepeat:
+		return collapseRepeats(body) != body
+	default:
+		return f.re.MatchString(body)
+	}
+}
+
+// Mask hides whatever the filter matches in body.
+func (f Filter) Mask(body string) string {
+	switch f.Kind {
+	case FilterKindCaps:
+		return strings.ToLower(body)
+	case FilterKindRepeat:
+		return collapseRepeats(body)
+	case FilterKindLinks:
+		return f.re.ReplaceAllLiteralString(body, "[link removed]")
+	default:
+		return f.re.ReplaceAllStringFunc(body, func(match string) string {
+			return strings.Repeat("*", len([]rune(match)))
+		})
+	}
+}
+
+func shouting(body string) bool {
+	letters, upper := 0, 0
+	for _, c := range body {
+		if unicode.IsLetter(c) {
+			letters++
+			if unicode.IsUpper(c) {
+				upper++
+			}
+		}
+	}
+
+	return letters >= capsMinLetters && float64(upper) >= capsRatio*float64(letters)
+}
+
+// collapseRepeats shortens runs of repeatRun or more of the same character
+// to three.
+func collapseRepeats(body string) string {
+	runes := []rune(body)
+	out := make([]rune, 0, len(runes))
+
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+
+		n := j - i
+		if n >= repeatRun {
+			n = 3
+		}
+		for range n {
+			out = append(out, runes[i])
+		}
+
+		i = j
+	}
+
+	return string(out)
+}
+
+// filter applies the room's filters to a message before it's posted,
+// returning the body to post or an error if it was blocked. Admins aren't
+// filtered.
+func (r *Room) filter(message ClientMessage, data ClientDataExternal) (string, error) {
+	body := message.Body
+	if data.OPLevel >= OPLevelAdmin {
+		return body, nil
+	}
+
+	key := muteKey(message)
+
+	var warned []string
+	for _, filter := range r.Rules.filters {
+		if !filter.Match(body) {
+			continue
+		}
+
+		switch filter.Action {
+		case FilterActionMask:
+			body = filter.Mask(body)
+		case FilterActionWarn:
+			warned = append(warned, string(filter.Kind))
+		case FilterActionReject:
+			return "", roomErrFiltered
+		case FilterActionMute:
+			r.filterHits[key]++
+			if r.filterHits[key] >= filter.Hits {
+				delete(r.filterHits, key)
+				r.mute(key, data.Nick, filterMuteDuration, "by the room's filters")
+			}
+			return "", roomErrFiltered
+		}
+	}
+
+	if len(warned) > 0 && message.Client != nil {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeNotice,
+			Body: fmt.Sprintf("warning: your message tripped the room's %s filter", strings.Join(warned, ", ")),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: message.Client,
+			},
+		}.Fill())
+	}
+
+	return body, nil
+}
+
+// mute stops a sender posting until the duration has passed.
+func (r *Room) mute(key any, nick string, duration time.Duration, reason string) {
+	r.mutes[key] = time.Now().Add(duration)
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeNotice,
+		Body: fmt.Sprintf("%s was muted for %s %s", nick, duration, reason),
+	}.Fill())
+}
+
+// muteKey identifies the sender of a message for mutes and filter hits.
+// Clients are keyed by session, so leaving and rejoining the room doesn't
+// clear a mute.
+func muteKey(message ClientMessage) any {
+	if message.Identity != nil {
+		return "identity:" + message.Identity.Nick
+	}
+
+	if message.Client.ID == "" {
+		return message.Client
+	}
+
+	return "session:" + message.Client.ID
+}
+
+// muted reports whether the sender of a message is muted, clearing mutes
+// that have run out.
+func (r *Room) muted(message ClientMessage) bool {
+	key := muteKey(message)
+
+	until, ok := r.mutes[key]
+	if ok && time.Now().After(until) {
+		delete(r.mutes, key)
+		return false
+	}
+
+	return ok
+}
+
+// expireMutes forgets mutes that have run out, including those of people
+// who never came back.
+func (r *Room) expireMutes(now time.Time) {
+	for key, until := range r.mutes {
+		if now.After(until) {
+			delete(r.mutes, key)
+		}
+	}
+}
+
+// moderate applies the room's mutes and filters to text a client posts
+// through a command rather than as a message, returning the text to use.
+func (r *Room) moderate(client *Client, data ClientDataExternal, text string) (string, error) {
+	message := ClientMessage{
+		Type:   MessageTypeCommand,
+		Client: client,
+		Body:   text,
+	}
+
+	if r.muted(message) {
+		return "", roomErrMuted
+	}
+
+	return r.filter(message, data)
+}
+
+func (r *Room) filterCommand(client *Client, args []string) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	switch args[0] {
+	case "add":
+		filter, err := ParseFilter(args[1:])
+		if err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+
+		r.Rules.filterID++
+		filter.ID = r.Rules.filterID
+		r.Rules.filters = append(r.Rules.filters, filter)
+
+		reply(MessageTypeCommand, fmt.Sprintf("added filter %s", filter))
+
+	case "remove":
+		if len(args) != 2 {
+			reply(MessageTypeError, "usage: /filter remove <id>")
+			return
+		}
+
+		id, err := strconv.Atoi(args[1])
+		i := slices.IndexFunc(r.Rules.filters, func(filter Filter) bool {
+			return filter.ID == id
+		})
+		if err != nil || i < 0 {
+			reply(MessageTypeError, fmt.Sprintf("no filter with id %s", args[1]))
+			return
+		}
+
+		filter := r.Rules.filters[i]
+		r.Rules.filters = slices.Delete(slices.Clone(r.Rules.filters), i, i+1)
+
+		reply(MessageTypeCommand, fmt.Sprintf("removed filter %s", filter))
+
+	case "list":
+		if len(r.Rules.filters) == 0 {
+			reply(MessageTypeCommand, "no filters")
+			return
+		}
+
+		lines := make([]string, 0, len(r.Rules.filters)+1)
+		lines = append(lines, "filters:")
+		for _, filter := range r.Rules.filters {
+			lines = append(lines, "  "+filter.String())
+		}
+
+		reply(MessageTypeCommand, strings.Join(lines, "\n"))
+
+	default:
+		reply(MessageTypeError, fmt.Sprintf("unknown filter subcommand: %s", args[0]))
+	}
+}
+
+func (r *Room) muteCommand(client *Client, data ClientDataExternal, nick string, duration *string, mute bool) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	var target *Client
+	for other, otherData := range r.Clients {
+		if otherData.Nick == nick {
+			target = other
+			break
+		}
+	}
+
+	if target == nil {
+		reply(MessageTypeError, fmt.Sprintf("%s is not in this room", nick))
+		return
+	}
+
+	key := muteKey(ClientMessage{Client: target})
+
+	if !mute {
+		if _, ok := r.mutes[key]; !ok {
+			reply(MessageTypeError, fmt.Sprintf("%s is not muted", nick))
+			return
+		}
+
+		delete(r.mutes, key)
+		r.enqueue(RoomMessage{
+			Type: MessageTypeNotice,
+			Body: fmt.Sprintf("%s unmuted %s", data.Nick, nick),
+		}.Fill())
+		return
+	}
+
+	length := filterMuteDuration
+	if duration != nil {
+		parsed, err := time.ParseDuration(*duration)
+		if err != nil || parsed <= 0 {
+			reply(MessageTypeError, fmt.Sprintf("invalid duration: %s", *duration))
+			return
+		}
+		length = parsed
+	}
+
+	r.mute(key, nick, length, fmt.Sprintf("by %s", data.Nick))
+}
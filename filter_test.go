@@ -0,0 +1,101 @@
+package main
+
+import "testing"
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    Filter
+		wantErr bool
+	}{
+		{args: []string{"word", "darn"}, want: Filter{Kind: FilterKindWord, Pattern: "darn", Action: FilterActionReject}},
+		{args: []string{"word", "darn", "--action", "mask"}, want: Filter{Kind: FilterKindWord, Pattern: "darn", Action: FilterActionMask}},
+		{args: []string{"--action", "mute", "links"}, want: Filter{Kind: FilterKindLinks, Action: FilterActionMute, Hits: filterDefaultHits}},
+		{args: []string{"caps", "--action", "mute", "--hits", "5"}, want: Filter{Kind: FilterKindCaps, Action: FilterActionMute, Hits: 5}},
+		{args: []string{"regex", "^spam+$", "--action", "warn"}, want: Filter{Kind: FilterKindRegex, Pattern: "^spam+$", Action: FilterActionWarn}},
+		{args: []string{"repeat"}, want: Filter{Kind: FilterKindRepeat, Action: FilterActionReject}},
+		{args: []string{}, wantErr: true},
+		{args: []string{"word"}, wantErr: true},
+		{args: []string{"word", ""}, wantErr: true},
+		{args: []string{"links", "example.com"}, wantErr: true},
+		{args: []string{"bogus"}, wantErr: true},
+		{args: []string{"regex", "("}, wantErr: true},
+		{args: []string{"caps", "--action"}, wantErr: true},
+		{args: []string{"caps", "--action", "explode"}, wantErr: true},
+		{args: []string{"caps", "--hits", "2"}, wantErr: true},
+		{args: []string{"caps", "--action", "mute", "--hits", "0"}, wantErr: true},
+		{args: []string{"caps", "--action", "mute", "--hits", "lots"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFilter(test.args)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFilter(%q) error = %v, want error %t", test.args, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		filter.re = nil
+		if filter != test.want {
+			t.Errorf("ParseFilter(%q) = %+v, want %+v", test.args, filter, test.want)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		args   []string
+		body   string
+		match  bool
+		masked string
+	}{
+		{[]string{"word", "darn", "--action", "mask"}, "oh Darn it", true, "oh **** it"},
+		{[]string{"word", "darn", "--action", "mask"}, "darnation", false, "darnation"},
+		{[]string{"links", "--action", "mask"}, "see https://example.com now", true, "see [link removed] now"},
+		{[]string{"caps", "--action", "mask"}, "THIS IS VERY LOUD", true, "this is very loud"},
+		{[]string{"caps", "--action", "mask"}, "OK", false, "OK"},
+		{[]string{"repeat", "--action", "mask"}, "nooooooo", true, "nooo"},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFilter(test.args)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q) error = %v", test.args, err)
+		}
+
+		if match := filter.Match(test.body); match != test.match {
+			t.Errorf("%s matching %q = %t, want %t", filter, test.body, match, test.match)
+		}
+		if test.match {
+			if masked := filter.Mask(test.body); masked != test.masked {
+				t.Errorf("%s masking %q = %q, want %q", filter, test.body, masked, test.masked)
+			}
+		}
+	}
+}
+
+func TestResolveMute(t *testing.T) {
+	room := NewRoom("test")
+
+	moderator := &Client{ID: "moderator"}
+	target := &Client{ID: "target"}
+	room.Clients[moderator] = ClientDataExternal{Nick: "mod", OPLevel: OPLevelAdmin}
+	room.Clients[target] = ClientDataExternal{Nick: "spammer", OPLevel: OPLevelUser}
+	room.reports = append(room.reports, &Report{ID: 1, Reporter: "mod", Nick: "spammer"})
+
+	if err := room.resolve(moderator, room.Clients[moderator], []string{"1", "mute"}); err != nil {
+		t.Fatalf("resolve error = %v", err)
+	}
+
+	if _, err := room.moderate(target, room.Clients[target], "still here"); err != roomErrMuted {
+		t.Errorf("moderate after /resolve mute error = %v, want %v", err, roomErrMuted)
+	}
+
+	// Rejoining gives a new client with the same session
+	rejoined := &Client{ID: "target"}
+	if !room.muted(ClientMessage{Client: rejoined}) {
+		t.Error("mute didn't survive rejoining")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"slices"
+	"strings"
+)
+
+// Format renders a message body as HTML, supporting a small, safe subset
+// of Markdown: **bold**, *italic* or _italic_, `code`, fenced code blocks,
+// ||spoilers||, > block quotes and bare http(s) links. Mentioned nicks are
+// highlighted. Everything else is escaped.
+func Format(body string, mentions []string) template.HTML {
+	f := formatter{mentions: mentions}
+
+	var b strings.Builder
+	lines := strings.Split(body, "\n")
+
+	// Whether the last thing written was a block element, which doesn't
+	// need a newline after it
+	block := true
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.HasPrefix(line, "```"):
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(lines[i], "```"); i++ {
+				code = append(code, lines[i])
+			}
+
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>")
+			block = true
+
+		case strings.HasPrefix(line, ">"):
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(lines[i], ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(lines[i], ">"), " "))
+			}
+			i--
+
+			b.WriteString("<blockquote>")
+			b.WriteString(f.inline(strings.Join(quote, "\n")))
+			b.WriteString("</blockquote>")
+			block = true
+
+		default:
+			if !block {
+				b.WriteString("\n")
+			}
+			b.WriteString(f.inline(line))
+			block = false
+		}
+	}
+
+	return template.HTML(b.String())
+}
+
+type formatter struct {
+	mentions []string
+}
+
+func (f formatter) inline(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		boundary := i == 0 || strings.ContainsRune(" \t\n([{", rune(s[i-1]))
+
+		switch {
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				b.WriteString("<code>")
+				b.WriteString(html.EscapeString(rest[1 : end+1]))
+				b.WriteString("</code>")
+				i += end + 2
+				continue
+			}
+
+		case boundary && (strings.HasPrefix(rest, "https://") || strings.HasPrefix(rest, "http://")):
+			end := strings.IndexAny(rest, " \t\n<>\"")
+			if end < 0 {
+				end = len(rest)
+			}
+			link := strings.TrimRight(rest[:end], ".,!?:;)]}'*_|")
+
+			if !strings.HasSuffix(link, "://") {
+				escaped := html.EscapeString(link)
+				b.WriteString(`<a href="` + escaped + `" target="_blank" rel="noopener noreferrer nofollow">` + escaped + `</a>`)
+				i += len(link)
+				continue
+			}
+
+		case boundary && rest[0] == '@':
+			nick := f.mention(rest[1:])
+			if nick != "" {
+				b.WriteString(`<span class="mention">@` + html.EscapeString(nick) + `</span>`)
+				i += len(nick) + 1
+				continue
+			}
+
+		case strings.HasPrefix(rest, "||"):
+			if inner, ok := delimited(rest, "||"); ok {
+				b.WriteString(`<span class="spoiler">` + f.inline(inner) + `</span>`)
+				i += len(inner) + 4
+				continue
+			}
+
+		case strings.HasPrefix(rest, "**"):
+			if inner, ok := delimited(rest, "**"); ok {
+				b.WriteString("<strong>" + f.inline(inner) + "</strong>")
+				i += len(inner) + 4
+				continue
+			}
+
+		case rest[0] == '*' || (boundary && rest[0] == '_'):
+			if inner, ok := delimited(rest, rest[:1]); ok {
+				b.WriteString("<em>" + f.inline(inner) + "</em>")
+				i += len(inner) + 2
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+
+	return b.String()
+}
+
+// mention returns the longest mentioned nick that s starts with.
+func (f formatter) mention(s string) string {
+	var longest string
+	for _, nick := range f.mentions {
+		if len(nick) > len(longest) && strings.HasPrefix(s, nick) {
+			longest = nick
+		}
+	}
+
+	return longest
+}
+
+// delimited returns the text between a delimiter at the start of s and its
+// closing pair, which must hug non-space text on both sides.
+func delimited(s string, delim string) (string, bool) {
+	rest := s[len(delim):]
+
+	end := strings.Index(rest, delim)
+	if end <= 0 {
+		return "", false
+	}
+
+	inner := rest[:end]
+	if strings.TrimSpace(inner) != inner {
+		return "", false
+	}
+
+	return inner, true
+}
+
+// formatMessage fills in a posted message's HTML, unless the room has
+// formatting turned off.
+func (r *Room) formatMessage(message *RoomMessage) {
+	if r.Rules.noFormatting {
+		return
+	}
+
+	mentions := slices.Clone(message.Mentions)
+	if message.MentionAll {
+		mentions = append(mentions, "here", "room")
+	}
+
+	message.HTML = Format(message.Body, mentions)
+}
+
+func (r *Room) formatting(client *Client, setting string) {
+	var body string
+
+	switch setting {
+	case "on":
+		r.Rules.noFormatting = false
+		body = "message formatting enabled"
+	case "off":
+		r.Rules.noFormatting = true
+		body = "message formatting disabled"
+	default:
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: fmt.Sprintf("unknown setting %s, use on or off", setting),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+		return
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeNotice,
+		Body: body,
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: client,
+		},
+	}.Fill())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		body string
+		want template.HTML
+	}{
+		{"plain text", "plain text"},
+		{"<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"**bold** and *italic* and _italic_", "<strong>bold</strong> and <em>italic</em> and <em>italic</em>"},
+		{"**unclosed", "**unclosed"},
+		{"** not bold **", "** not bold **"},
+		{"snake_case_name", "snake_case_name"},
+		{"`<b>` stays code", "<code>&lt;b&gt;</code> stays code"},
+		{"||spoiler||", `<span class="spoiler">spoiler</span>`},
+		{"```\na <b>\n```\nafter", "<pre><code>a &lt;b&gt;</code></pre>after"},
+		{"> quoted\n> twice\nreply", "<blockquote>quoted\ntwice</blockquote>reply"},
+		{"one\ntwo", "one\ntwo"},
+		{
+			"see https://example.com/a?b=1&c=2.",
+			`see <a href="https://example.com/a?b=1&amp;c=2" target="_blank" rel="noopener noreferrer nofollow">https://example.com/a?b=1&amp;c=2</a>.`,
+		},
+		{
+			`https://example.com/"onmouseover=x`,
+			`<a href="https://example.com/" target="_blank" rel="noopener noreferrer nofollow">https://example.com/</a>&#34;onmouseover=x`,
+		},
+		{"javascript:alert(1)", "javascript:alert(1)"},
+		{"hi @bob, @alice", `hi <span class="mention">@bob</span>, @alice`},
+	}
+
+	for _, test := range tests {
+		if got := Format(test.body, []string{"bob"}); got != test.want {
+			t.Errorf("Format(%q) = %q, want %q", test.body, got, test.want)
+		}
+	}
+}
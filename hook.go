@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/lucasb-eyer/go-colorful"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	hookMaxBody    = 64 << 10
+	hookMaxPerRoom = 10
+	hookTimeout    = 5 * time.Second
+)
+
+// IncomingHook lets external systems post into a room through a secret URL.
+type IncomingHook struct {
+	Name  string
+	Token string
+	Room  string
+	Nick  string
+	Color string
+}
+
+// IncomingHooks maps a hook's secret token to the hook.
+var IncomingHooks = NewMuMap[string, *IncomingHook]()
+
+func (h *IncomingHook) URL() string {
+	return fmt.Sprintf("%s/hooks/%s", config.PublicURL, h.Token)
+}
+
+type HookRequest struct {
+	Text string `json:"text"`
+	Body string `json:"body"`
+}
+
+func HookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := IncomingHooks.Get(chi.URLParam(r, "token"))
+	if !ok {
+		http.Error(w, "Unknown hook.", http.StatusNotFound)
+		return
+	}
+
+	room, ok := Rooms.Get(hook.Room)
+	if !ok {
+		http.Error(w, "Room is closed.", http.StatusGone)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, hookMaxBody))
+	if err != nil {
+		http.Error(w, "Body too large.", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body := string(data)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req HookRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
+			return
+		}
+
+		body = req.Text
+		if body == "" {
+			body = req.Body
+		}
+	}
+
+	if strings.TrimSpace(body) == "" {
+		http.Error(w, "Empty message.", http.StatusBadRequest)
+		return
+	}
+
+	timeout := time.After(hookTimeout)
+
+	reply := make(chan error, 1)
+	select {
+	case room.External <- ClientMessage{
+		Type: MessageTypeBot,
+		Body: body,
+		Identity: &ClientDataExternal{
+			Nick:    hook.Nick,
+			Color:   hook.Color,
+			OPLevel: OPLevelUser,
+		},
+		Reply: reply,
+	}:
+	case <-room.closed:
+		http.Error(w, "Room is closed.", http.StatusGone)
+		return
+	case <-timeout:
+		http.Error(w, "Room did not respond.", http.StatusGatewayTimeout)
+		return
+	}
+
+	select {
+	case err := <-reply:
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, roomErrRateLimited):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	case <-room.closed:
+		http.Error(w, "Room is closed.", http.StatusGone)
+	case <-timeout:
+		http.Error(w, "Room did not respond.", http.StatusGatewayTimeout)
+	}
+}
+
+func (r *Room) hook(client *Client, args []string) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	find := func(name string) int {
+		for i, hook := range r.hooks {
+			if hook.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) < 2 {
+			reply(MessageTypeError, "usage: /hook create <name> [nick] [color]")
+			return
+		}
+
+		if find(args[1]) >= 0 {
+			reply(MessageTypeError, fmt.Sprintf("hook %s already exists", args[1]))
+			return
+		}
+
+		if len(r.hooks) >= hookMaxPerRoom {
+			reply(MessageTypeError, fmt.Sprintf("rooms can have at most %d hooks", hookMaxPerRoom))
+			return
+		}
+
+		hook := &IncomingHook{
+			Name:  args[1],
+			Token: randomToken(16),
+			Room:  r.Name,
+			Nick:  args[1],
+			Color: messageColors[MessageTypeBot],
+		}
+
+		if len(args) > 2 {
+			hook.Nick = args[2]
+		}
+
+		if len(args) > 3 {
+			color, err := colorful.Hex(args[3])
+			if err != nil {
+				reply(MessageTypeError, fmt.Sprintf("invalid color: %s", args[3]))
+				return
+			}
+			hook.Color = color.Hex()
+		}
+
+		r.hooks = append(r.hooks, hook)
+		IncomingHooks.Set(hook.Token, hook)
+
+		reply(MessageTypeNotice, fmt.Sprintf("hook %s created, posting as %s\nPOST to: %s", hook.Name, hook.Nick, hook.URL()))
+
+	case "delete":
+		if len(args) < 2 {
+			reply(MessageTypeError, "usage: /hook delete <name>")
+			return
+		}
+
+		i := find(args[1])
+		if i < 0 {
+			reply(MessageTypeError, fmt.Sprintf("no hook named %s", args[1]))
+			return
+		}
+
+		IncomingHooks.Delete(r.hooks[i].Token)
+		r.hooks = append(r.hooks[:i], r.hooks[i+1:]...)
+
+		reply(MessageTypeNotice, fmt.Sprintf("hook %s deleted", args[1]))
+
+	case "list":
+		if len(r.hooks) == 0 {
+			reply(MessageTypeCommand, "no hooks are configured")
+			return
+		}
+
+		lines := make([]string, 0, len(r.hooks))
+		for _, hook := range r.hooks {
+			lines = append(lines, fmt.Sprintf("%s as %s: %s", hook.Name, hook.Nick, hook.URL()))
+		}
+
+		reply(MessageTypeCommand, fmt.Sprintf("hooks:\n%s", strings.Join(lines, "\n")))
+
+	default:
+		reply(MessageTypeError, fmt.Sprintf("unknown hook subcommand: %s\nUsage:\n  %s", args[0], Commands["hook"].Help))
+	}
+}
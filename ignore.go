@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// IgnoreEntry is someone a user doesn't want to see. Addr, if set, also
+// hides them under other nicks; it's never shown back to the user.
+type IgnoreEntry struct {
+	Nick string
+	Addr string
+}
+
+// ignoreStore keeps each session's ignore list, so it survives the user
+// reconnecting or moving between rooms.
+type ignoreStore struct {
+	mu    sync.RWMutex
+	lists map[string][]IgnoreEntry
+}
+
+var Ignores = &ignoreStore{
+	lists: make(map[string][]IgnoreEntry),
+}
+
+func (s *ignoreStore) Add(id string, entry IgnoreEntry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.lists[id], func(test IgnoreEntry) bool {
+		return test.Nick == entry.Nick
+	})
+	if i >= 0 {
+		if s.lists[id][i] == entry {
+			return false
+		}
+		s.lists[id][i] = entry
+		return true
+	}
+
+	s.lists[id] = append(s.lists[id], entry)
+	return true
+}
+
+func (s *ignoreStore) Remove(id string, nick string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.lists[id]
+	n := len(list)
+	list = slices.DeleteFunc(list, func(entry IgnoreEntry) bool {
+		return entry.Nick == nick
+	})
+
+	if len(list) == 0 {
+		delete(s.lists, id)
+	} else {
+		s.lists[id] = list
+	}
+
+	return len(list) != n
+}
+
+func (s *ignoreStore) List(id string) []IgnoreEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.lists[id])
+}
+
+// Ignoring reports whether the session ignores the sender of a message.
+// Messages that no user sent are never ignored.
+func (s *ignoreStore) Ignoring(id string, message RoomMessage) bool {
+	if id == "" || message.From == nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entry := range s.lists[id] {
+		if entry.Nick == message.From.Nick || (entry.Addr != "" && entry.Addr == message.From.Addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addrHost strips the port from a remote address.
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func (r *Room) ignore(client *Client, data ClientDataExternal, args []string) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	nick := args[0]
+	byAddr := len(args) > 1
+	if byAddr && args[1] != "--addr" {
+		reply(MessageTypeError, fmt.Sprintf("unknown option: %s\nUsage:\n  %s", args[1], Commands["ignore"].Help))
+		return
+	}
+
+	if client.ID == "" {
+		reply(MessageTypeError, "only signed in users can ignore others")
+		return
+	}
+
+	if nick == data.Nick {
+		reply(MessageTypeError, "you can't ignore yourself")
+		return
+	}
+
+	entry := IgnoreEntry{Nick: nick}
+	if byAddr {
+		var target *Client
+		for other, otherData := range r.Clients {
+			if otherData.Nick == nick {
+				target = other
+				break
+			}
+		}
+
+		if target == nil || target.Bot != nil {
+			reply(MessageTypeError, fmt.Sprintf("%s must be in this room to ignore them by address", nick))
+			return
+		}
+
+		entry.Addr = addrHost(target.Addr)
+		if entry.Addr == addrHost(client.Addr) {
+			reply(MessageTypeError, fmt.Sprintf("%s shares your address, ignore them by nick instead", nick))
+			return
+		}
+	}
+
+	if !Ignores.Add(client.ID, entry) {
+		reply(MessageTypeError, fmt.Sprintf("you are already ignoring %s", nick))
+		return
+	}
+
+	reply(MessageTypeCommand, fmt.Sprintf("ignoring %s", nick))
+}
+
+func (r *Room) unignore(client *Client, nick string) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	if !Ignores.Remove(client.ID, nick) {
+		reply(MessageTypeError, fmt.Sprintf("you aren't ignoring %s", nick))
+		return
+	}
+
+	reply(MessageTypeCommand, fmt.Sprintf("no longer ignoring %s", nick))
+}
+
+func (r *Room) listIgnores(client *Client) {
+	entries := Ignores.List(client.ID)
+
+	body := "you aren't ignoring anyone"
+	if len(entries) > 0 {
+		lines := make([]string, 0, len(entries)+1)
+		lines = append(lines, "ignoring:")
+		for _, entry := range entries {
+			if entry.Addr != "" {
+				lines = append(lines, fmt.Sprintf("  %s (and their address)", entry.Nick))
+			} else {
+				lines = append(lines, fmt.Sprintf("  %s", entry.Nick))
+			}
+		}
+		body = strings.Join(lines, "\n")
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeCommand,
+		Body: body,
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: client,
+		},
+	}.Fill())
+}
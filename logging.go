@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type LogConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string `json:"level"`
+
+	// Trace lists rooms whose every event is logged, for debugging.
+	Trace []string `json:"trace"`
+}
+
+// SetupLogging makes the default logger write JSON at the configured
+// level.
+func SetupLogging(c LogConfig) error {
+	level := slog.LevelInfo
+	if c.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+			return fmt.Errorf("invalid log level: %w", err)
+		}
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	return nil
+}
+
+// logID shortens a session ID to something that identifies it in logs
+// without giving the session away.
+func logID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:4])
+}
+
+func clientAttr(client *Client, nick string) slog.Attr {
+	return slog.Group("client",
+		slog.String("id", logID(client.ID)),
+		slog.String("nick", nick),
+		slog.String("addr", client.Addr),
+	)
+}
+
+func (r *Room) logger() *slog.Logger {
+	return slog.With("room", r.Name)
+}
+
+// traceEvent logs an event in the room's loop if the room is traced.
+func (r *Room) traceEvent(event string, args ...any) {
+	if r.trace {
+		r.logger().Info("trace", append([]any{"event", event}, args...)...)
+	}
+}
+
+// RequestLogger logs each HTTP request once it's been served. It logs the
+// route rather than the path, which can hold secrets such as hook tokens.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		slog.Info("request",
+			"method", r.Method,
+			"route", route,
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"addr", r.RemoteAddr,
+		)
+	})
+}
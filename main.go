@@ -1,27 +1,55 @@
 package main
 
 import (
+	"flag"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 )
 
-var templates = template.Must(template.ParseGlob("templates/*.tmpl"))
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"pathescape": url.PathEscape,
+}).ParseGlob("templates/*.tmpl"))
 
 const DefaultRoom = "main"
 
 var Rooms = NewMuMap[string, *Room]()
 
+var config = DefaultConfig()
+
 func main() {
+	configPath := flag.String("config", "", "path to a JSON config file")
+	flag.Parse()
+
+	var err error
+	config, err = LoadConfig(*configPath)
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	if err := SetupLogging(config.Log); err != nil {
+		slog.Error("failed to set up logging", "error", err)
+		os.Exit(1)
+	}
+
+	if err := LoadBots(config.Bots); err != nil {
+		slog.Error("failed to load bots", "error", err)
+		os.Exit(1)
+	}
+
 	// Initialise router
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.Logger)
+	r.Use(RequestLogger)
 	r.Use(middleware.Recoverer)
+	r.Use(BanMiddleware)
 
 	// Serve static files
 	workDir, _ := filepath.Abs(".")
@@ -30,7 +58,17 @@ func main() {
 
 	r.Get("/", Handler)
 	r.Get("/ws", WSHandler)
+	r.Get("/events", EventsHandler)
+	r.Post("/send", SendHandler)
 	// r.Get("/wsapi", WSAPIHandler)
+	r.Route("/api", APIRoutes)
+	r.Route("/admin", DashboardRoutes)
+	r.With(apiAuth, apiAdmin).Get("/metrics", MetricsHandler)
+	r.Get("/healthz", HealthHandler)
+	r.Get("/readyz", ReadyHandler)
+	r.Post("/hooks/{token}", HookHandler)
+	r.Post("/rooms/{name}/upload", UploadHandler)
+	r.Get("/uploads/{dir}/{file}", UploadsHandler)
 
 	roomMain := NewRoom(DefaultRoom)
 	roomMain.Rules.
@@ -41,12 +79,21 @@ func main() {
 
 	Rooms.Set(DefaultRoom, roomMain)
 	go roomMain.Run()
+	AttachBots(roomMain)
+
+	if err := StartWatchdog(config.Watchdog); err != nil {
+		slog.Error("failed to start watchdog", "error", err)
+		os.Exit(1)
+	}
 
 	// Start server
-	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	slog.Info("server starting", "addr", config.Addr)
+	err = http.ListenAndServe(config.Addr, r)
+	slog.Error("server stopped", "error", err)
+	os.Exit(1)
 }
 
-func Handler(w http.ResponseWriter, _ *http.Request) {
+func Handler(w http.ResponseWriter, r *http.Request) {
+	ensureSession(w, r)
 	execute(w, "room.tmpl", nil)
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// mentionPattern matches @nick at the start of the body or after a space
+// or bracket, so email addresses aren't taken for mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[\s(])@([^\s@]+)`)
+
+const (
+	// mentionMaxRemote caps how many people outside the room one message
+	// can notify
+	mentionMaxRemote = 5
+
+	mentionRateBurst     = 10
+	mentionRatePerSecond = 0.2
+)
+
+var roomErrMentionAll = errors.New("only admins can mention everyone with @here or @room")
+
+// mentions finds the nicks mentioned in a message body. Nicks of people in
+// the room are returned in local, anything else that looks like a mention
+// in remote, and all reports @here or @room.
+func (r *Room) mentions(body string) (local []string, remote []string, all bool) {
+	nicks := make(map[string]bool, len(r.Clients))
+	for _, data := range r.Clients {
+		if data.Nick != "" {
+			nicks[data.Nick] = true
+		}
+	}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		token := match[1]
+
+		// Allow trailing punctuation, unless it's part of the nick
+		nick := token
+		if !nicks[nick] {
+			nick = strings.TrimRight(token, ".,!?:;)'\"")
+		}
+
+		switch {
+		case nick == "here" || nick == "room":
+			all = true
+		case nicks[nick]:
+			if !slices.Contains(local, nick) {
+				local = append(local, nick)
+			}
+		case nick != "":
+			if !slices.Contains(remote, nick) {
+				remote = append(remote, nick)
+			}
+		}
+	}
+
+	return local, remote, all
+}
+
+// MentionsNick reports whether the message mentions the given nick.
+func (m RoomMessage) MentionsNick(nick string) bool {
+	if nick == "" || m.Nick == nick {
+		return false
+	}
+
+	return m.MentionAll || slices.Contains(m.Mentions, nick)
+}
+
+// notifyMentions lets people mentioned in a message who aren't in the room
+// know about it, wherever they are. Each nick is resolved once, and the
+// sender's notifications are rate limited.
+func (r *Room) notifyMentions(sender ClientMessage, message RoomMessage, nicks []string) {
+	snippet := NewReply(message).Snippet
+
+	key := senderKey(sender)
+	bucket, ok := r.mentionLimits[key]
+	if !ok {
+		bucket = newTokenBucket(mentionRateBurst, mentionRatePerSecond)
+		r.mentionLimits[key] = bucket
+	}
+
+	now := time.Now()
+	for _, nick := range nicks[:min(len(nicks), mentionMaxRemote)] {
+		entry, ok := Directory.Lookup(nick)
+		if !ok || entry.Room == r {
+			continue
+		}
+
+		if !bucket.Allow(now) {
+			Metrics.Errors.Inc(errorKind(roomErrRateLimited))
+			return
+		}
+
+		select {
+		case entry.Room.Internal <- RoomMessage{
+			Type: MessageTypeNotice,
+			Body: fmt.Sprintf("%s mentioned you in %s: %s", message.Nick, r.Name, snippet),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: entry.Client,
+			},
+			From: message.From,
+		}.Fill():
+		default:
+		}
+	}
+}
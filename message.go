@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"html/template"
 	"strings"
 	"time"
 )
@@ -9,27 +10,94 @@ import (
 type MessageType string
 
 const (
-	MessageTypeMessage MessageType = "message"
-	MessageTypeCommand MessageType = "command"
-	MessageTypeWhisper MessageType = "whisper"
-	MessageTypeError   MessageType = "error"
-	MessageTypeNotice  MessageType = "notice"
-	MessageTypeJoin    MessageType = "join"
-	MessageTypeLeave   MessageType = "leave"
-	MessageTypeReset   MessageType = "reset"
+	MessageTypeMessage   MessageType = "message"
+	MessageTypeCommand   MessageType = "command"
+	MessageTypeWhisper   MessageType = "whisper"
+	MessageTypeError     MessageType = "error"
+	MessageTypeNotice    MessageType = "notice"
+	MessageTypeJoin      MessageType = "join"
+	MessageTypeLeave     MessageType = "leave"
+	MessageTypeReset     MessageType = "reset"
+	MessageTypeBot       MessageType = "bot"
+	MessageTypeThread    MessageType = "thread"
+	MessageTypeReactions MessageType = "reactions"
+	MessageTypeAction    MessageType = "action"
+	MessageTypeRoom      MessageType = "room"
+	MessageTypeTyping    MessageType = "typing"
+	MessageTypeDivider   MessageType = "divider"
+	MessageTypePins      MessageType = "pins"
+	MessageTypePoll      MessageType = "poll"
+	MessageTypeVotes     MessageType = "votes"
+	MessageTypeDM        MessageType = "dm"
 )
 
+// IsChat reports whether messages of this type are something someone said,
+// which can be replied and reacted to.
+func (t MessageType) IsChat() bool {
+	switch t {
+	case MessageTypeMessage, MessageTypeBot, MessageTypeAction:
+		return true
+	default:
+		return false
+	}
+}
+
 type ClientMessage struct {
 	Type    MessageType
 	Client  *Client
 	Body    string
 	Command *Command
+
+	// Identity stands in for the room's data on the sender when the message
+	// doesn't come from a connected client, e.g. API posts.
+	Identity *ClientDataExternal
+
+	// Reply, if set, receives nil once the message is accepted or the error
+	// it was rejected with. It must be buffered.
+	Reply chan error
+
+	Attachment *Attachment
+
+	// Typing is whether a typing frame starts or stops typing
+	Typing bool
+}
+
+// As turns a command into the message it sends, keeping its sender.
+func (m ClientMessage) As(messageType MessageType, body string) ClientMessage {
+	return ClientMessage{
+		Type:       messageType,
+		Client:     m.Client,
+		Body:       body,
+		Identity:   m.Identity,
+		Reply:      m.Reply,
+		Attachment: m.Attachment,
+	}
+}
+
+// Sender identifies who sent a message, so recipients can ignore them.
+type Sender struct {
+	Nick string
+	Addr string
+}
+
+func NewSender(client *Client, data ClientDataExternal) *Sender {
+	sender := &Sender{Nick: data.Nick}
+	if client != nil {
+		sender.Addr = addrHost(client.Addr)
+	}
+	return sender
 }
 
 func (m ClientMessage) Promote(data ClientDataExternal) RoomMessage {
+	messageType := MessageTypeMessage
+	switch m.Type {
+	case MessageTypeBot, MessageTypeAction, MessageTypeNotice:
+		messageType = m.Type
+	}
+
 	return RoomMessage{
 		ID:    messageID(),
-		Type:  MessageTypeMessage,
+		Type:  messageType,
 		Time:  time.Now(),
 		Nick:  data.Nick,
 		Color: data.Color,
@@ -37,6 +105,8 @@ func (m ClientMessage) Promote(data ClientDataExternal) RoomMessage {
 		Target: Target{
 			Type: TargetTypeAll,
 		},
+		Attachment: m.Attachment,
+		From:       NewSender(m.Client, data),
 	}
 }
 
@@ -48,6 +118,9 @@ var messageColors = map[MessageType]string{
 	MessageTypeNotice:  "#f9e2af", // yellow – gentle alert / info
 	MessageTypeJoin:    "#a6e3a1", // green – success/positive event
 	MessageTypeLeave:   "#eba0ac", // maroon – softer farewell than pure red
+	MessageTypeBot:     "#94e2d5", // teal – automated posts stand apart from people
+	MessageTypeAction:  "#f5c2e7", // pink – emotes read as narration
+	MessageTypeDM:      "#cba6f7", // mauve – private, like whispers
 }
 
 type RoomMessage struct {
@@ -58,6 +131,97 @@ type RoomMessage struct {
 	Color  string      `json:"color"`
 	Body   string      `json:"body"`
 	Target Target      `json:"target"`
+
+	// To is the recipient of a direct message
+	To string `json:"to,omitempty"`
+
+	// From is the user who sent the message, if anyone did
+	From *Sender `json:"-"`
+
+	// HTML is the formatted body, if the room formats messages. Body keeps
+	// the raw text.
+	HTML template.HTML `json:"html,omitempty"`
+
+	Mentions   []string `json:"mentions,omitempty"`
+	MentionAll bool     `json:"mention_all,omitempty"`
+
+	// ForYou is set on the copy sent to a recipient the message mentions
+	ForYou bool `json:"for_you,omitempty"`
+
+	Attachment *Attachment `json:"attachment,omitempty"`
+
+	// Typing lists who else is typing, for typing updates
+	Typing []string `json:"typing,omitempty"`
+
+	// Pins is the room's pinned messages, for pin updates
+	Pins []Pin `json:"pins,omitempty"`
+
+	// Poll is the poll a poll message starts, or its new tally for votes
+	Poll *Poll `json:"poll,omitempty"`
+
+	ReplyTo   *Reply     `json:"reply_to,omitempty"`
+	Thread    *Thread    `json:"thread,omitempty"`
+	Reactions *Reactions `json:"reactions,omitempty"`
+}
+
+// Reply links a message to the one it replies to, and to the root of the
+// thread they're both in.
+type Reply struct {
+	ID      string `json:"id"`
+	Root    string `json:"root"`
+	Nick    string `json:"nick"`
+	Snippet string `json:"snippet"`
+}
+
+// Thread updates the reply count shown on a thread's root message.
+type Thread struct {
+	Root    string `json:"root"`
+	Replies int    `json:"replies"`
+}
+
+// Reactions are the reactions on a message, in the order each emoji was
+// first used.
+type Reactions struct {
+	ID    string     `json:"id"`
+	Items []Reaction `json:"items"`
+}
+
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Nicks []string `json:"nicks"`
+
+	// ids holds the session ID of each reactor, parallel to Nicks, which
+	// are only for display. It's never sent to clients.
+	ids []string
+}
+
+func (r Reaction) Count() int {
+	return len(r.Nicks)
+}
+
+func (r Reaction) Title() string {
+	return strings.Join(r.Nicks, ", ")
+}
+
+const replySnippetLength = 80
+
+func NewReply(parent RoomMessage) *Reply {
+	root := parent.ID
+	if parent.ReplyTo != nil {
+		root = parent.ReplyTo.Root
+	}
+
+	snippet := strings.Join(strings.Fields(parent.Body), " ")
+	if runes := []rune(snippet); len(runes) > replySnippetLength {
+		snippet = string(runes[:replySnippetLength]) + "…"
+	}
+
+	return &Reply{
+		ID:      parent.ID,
+		Root:    root,
+		Nick:    parent.Nick,
+		Snippet: snippet,
+	}
 }
 
 func (m RoomMessage) Fill() RoomMessage {
@@ -84,6 +248,15 @@ func (m RoomMessage) Fill() RoomMessage {
 	return m
 }
 
+// ReactionsOrEmpty returns the message's reactions, which may be empty.
+func (m RoomMessage) ReactionsOrEmpty() Reactions {
+	if m.Reactions == nil {
+		return Reactions{ID: m.ID}
+	}
+
+	return *m.Reactions
+}
+
 func (m RoomMessage) Render() string {
 	var buf strings.Builder
 	err := templates.ExecuteTemplate(&buf, "message.tmpl", m)
@@ -108,9 +281,9 @@ const (
 )
 
 type Target struct {
-	Type   TargetType
-	Nick   string
-	Client *Client
+	Type   TargetType `json:"type"`
+	Nick   string     `json:"nick,omitempty"`
+	Client *Client    `json:"-"`
 }
 
 func (t Target) Should(client *Client, clientData ClientDataExternal) bool {
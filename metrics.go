@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// counterVec is a counter with a single label.
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: make(map[string]uint64)}
+}
+
+func (c *counterVec) Inc(label string) {
+	c.mu.Lock()
+	c.values[label]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer, name string, help string, label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, name, help, "counter")
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(key), c.values[key])
+	}
+}
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets ...float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, name, help, "histogram")
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+var Metrics = struct {
+	Messages *counterVec
+	Commands *counterVec
+	Errors   *counterVec
+
+	SlowConsumers   atomic.Uint64
+	UpgradeFailures atomic.Uint64
+	Stalls          atomic.Uint64
+	Restarts        atomic.Uint64
+
+	Registration *histogram
+}{
+	Messages: newCounterVec(),
+	Commands: newCounterVec(),
+	Errors:   newCounterVec(),
+
+	Registration: newHistogram(0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5),
+}
+
+// errorKind labels an error a room rejected a message with.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, roomErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, roomErrMuted):
+		return "muted"
+	case errors.Is(err, roomErrFiltered):
+		return "filtered"
+	case errors.Is(err, roomErrNoNick):
+		return "no_nick"
+	case errors.Is(err, roomErrMessagesDisabled):
+		return "messages_disabled"
+	case errors.Is(err, roomErrMentionAll):
+		return "mention_all"
+	default:
+		return "other"
+	}
+}
+
+// roomMetrics is a snapshot of a room's state for a scrape.
+type roomMetrics struct {
+	name     string
+	clients  int
+	bots     int
+	external int
+	internal int
+	register int
+	send     int
+	recv     int
+}
+
+// scrapeRoom reads a room's metrics without waiting on its loop: channel
+// lengths are safe to read from anywhere, and who's in the room comes from
+// its last checkpoint. Stuck rooms are skipped.
+func scrapeRoom(name string, room *Room) (roomMetrics, bool) {
+	if room.stuck() {
+		return roomMetrics{}, false
+	}
+
+	m := roomMetrics{
+		name:     name,
+		external: len(room.External),
+		internal: len(room.Internal),
+		register: len(room.Register),
+	}
+
+	if state := room.saved.Load(); state != nil {
+		for client := range state.clients {
+			if client.Bot != nil {
+				m.bots++
+			} else {
+				m.clients++
+			}
+			m.send += len(client.Send)
+			m.recv += len(client.Recv)
+		}
+	}
+
+	return m, true
+}
+
+// MetricsHandler serves metrics in the Prometheus text format. Room names
+// are in the labels, so it's behind the admin API key.
+func MetricsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	Rooms.Mu.RLock()
+	open := len(Rooms.M)
+	rooms := make([]roomMetrics, 0, open)
+	for name, room := range Rooms.M {
+		if m, ok := scrapeRoom(name, room); ok {
+			rooms = append(rooms, m)
+		}
+	}
+	Rooms.Mu.RUnlock()
+
+	slices.SortFunc(rooms, func(a, b roomMetrics) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	writeHeader(w, "chat_rooms_open", "Rooms currently open.", "gauge")
+	fmt.Fprintf(w, "chat_rooms_open %d\n", open)
+
+	gauge := func(name string, help string, value func(roomMetrics) int) {
+		writeHeader(w, name, help, "gauge")
+		for _, m := range rooms {
+			fmt.Fprintf(w, "%s{room=\"%s\"} %d\n", name, escapeLabel(m.name), value(m))
+		}
+	}
+
+	gauge("chat_room_clients", "Users connected to each room.", func(m roomMetrics) int { return m.clients })
+	gauge("chat_room_bots", "Bots attached to each room.", func(m roomMetrics) int { return m.bots })
+
+	writeHeader(w, "chat_room_queued_messages", "Messages waiting in each room's channels.", "gauge")
+	for _, m := range rooms {
+		for _, channel := range []struct {
+			name  string
+			value int
+		}{
+			{"external", m.external},
+			{"internal", m.internal},
+			{"register", m.register},
+			{"send", m.send},
+			{"recv", m.recv},
+		} {
+			fmt.Fprintf(w, "chat_room_queued_messages{room=\"%s\",channel=\"%s\"} %d\n", escapeLabel(m.name), channel.name, channel.value)
+		}
+	}
+
+	Metrics.Messages.write(w, "chat_messages_total", "Messages processed by rooms, by type.", "type")
+	Metrics.Commands.write(w, "chat_commands_total", "Commands processed, by name.", "command")
+	Metrics.Errors.write(w, "chat_errors_total", "Messages rejected, by kind.", "kind")
+
+	writeHeader(w, "chat_slow_consumers_total", "Clients removed because their send queue was full.", "counter")
+	fmt.Fprintf(w, "chat_slow_consumers_total %d\n", Metrics.SlowConsumers.Load())
+
+	writeHeader(w, "chat_websocket_upgrade_failures_total", "WebSocket upgrades that failed.", "counter")
+	fmt.Fprintf(w, "chat_websocket_upgrade_failures_total %d\n", Metrics.UpgradeFailures.Load())
+
+	writeHeader(w, "chat_room_stalls_total", "Room loops the watchdog found stuck.", "counter")
+	fmt.Fprintf(w, "chat_room_stalls_total %d\n", Metrics.Stalls.Load())
+
+	writeHeader(w, "chat_room_restarts_total", "Stuck rooms the watchdog restarted.", "counter")
+	fmt.Fprintf(w, "chat_room_restarts_total %d\n", Metrics.Restarts.Load())
+
+	Metrics.Registration.write(w, "chat_registration_seconds", "Time from a client asking to join a room to the room registering it.")
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
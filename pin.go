@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const maxPins = 25
+
+// Pin is a pinned message. It keeps its own copy of the snippet so it
+// outlives the message scrolling out of history.
+type Pin struct {
+	ID       string    `json:"id"`
+	Nick     string    `json:"nick"`
+	Snippet  string    `json:"snippet"`
+	PinnedBy string    `json:"pinned_by"`
+	Time     time.Time `json:"time"`
+}
+
+func (r *Room) pin(client *Client, data ClientDataExternal, id string) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	message, ok := r.message(id)
+	if !ok || !message.Type.IsChat() {
+		reply(MessageTypeError, fmt.Sprintf("no message with id %s", id))
+		return
+	}
+
+	for _, pin := range r.Rules.pins {
+		if pin.ID == id {
+			reply(MessageTypeError, fmt.Sprintf("message %s is already pinned", id))
+			return
+		}
+	}
+
+	if len(r.Rules.pins) >= maxPins {
+		reply(MessageTypeError, fmt.Sprintf("this room already has %d pinned messages", maxPins))
+		return
+	}
+
+	pin := Pin{
+		ID:       message.ID,
+		Nick:     message.Nick,
+		Snippet:  NewReply(message).Snippet,
+		PinnedBy: data.Nick,
+		Time:     time.Now(),
+	}
+	r.Rules.pins = append(r.Rules.pins, pin)
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeNotice,
+		Body: fmt.Sprintf("%s pinned a message from %s: %s", data.Nick, pin.Nick, pin.Snippet),
+	}.Fill())
+
+	r.broadcastPins()
+}
+
+func (r *Room) unpin(client *Client, data ClientDataExternal, id string) {
+	for i, pin := range r.Rules.pins {
+		if pin.ID != id {
+			continue
+		}
+
+		r.Rules.pins = append(r.Rules.pins[:i:i], r.Rules.pins[i+1:]...)
+
+		r.enqueue(RoomMessage{
+			Type: MessageTypeNotice,
+			Body: fmt.Sprintf("%s unpinned a message from %s: %s", data.Nick, pin.Nick, pin.Snippet),
+		}.Fill())
+
+		r.broadcastPins()
+		return
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeError,
+		Body: fmt.Sprintf("message %s is not pinned", id),
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: client,
+		},
+	}.Fill())
+}
+
+func (r *Room) listPins(client *Client) {
+	body := "no pinned messages"
+	if len(r.Rules.pins) > 0 {
+		lines := make([]string, 0, len(r.Rules.pins)+1)
+		lines = append(lines, "pinned messages:")
+		for _, pin := range r.Rules.pins {
+			lines = append(lines, fmt.Sprintf("  [%s] %s: %s (pinned by %s)", pin.ID, pin.Nick, pin.Snippet, pin.PinnedBy))
+		}
+		body = strings.Join(lines, "\n")
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeCommand,
+		Body: body,
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: client,
+		},
+	}.Fill())
+}
+
+// pinsMessage updates the pinned messages header.
+func (r *Room) pinsMessage() RoomMessage {
+	return RoomMessage{
+		Type: MessageTypePins,
+		Pins: r.Rules.pins,
+	}.Fill()
+}
+
+func (r *Room) broadcastPins() {
+	r.enqueue(r.pinsMessage())
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pollMinOptions  = 2
+	pollMaxOptions  = 10
+	pollMaxDuration = 24 * time.Hour
+
+	// pollRetention is how long a closed poll is kept, so late votes get a
+	// sensible error
+	pollRetention = time.Hour
+)
+
+// Poll is the state of a poll as shown to the room. Anonymous polls don't
+// list who voted for what.
+type Poll struct {
+	ID        string       `json:"id"`
+	Question  string       `json:"question"`
+	Options   []PollOption `json:"options"`
+	Total     int          `json:"total"`
+	Anonymous bool         `json:"anonymous"`
+	Closed    bool         `json:"closed"`
+	Expires   time.Time    `json:"expires,omitzero"`
+}
+
+type PollOption struct {
+	Number  int      `json:"number"`
+	Text    string   `json:"text"`
+	Votes   int      `json:"votes"`
+	Percent int      `json:"percent"`
+	Nicks   []string `json:"nicks,omitempty"`
+}
+
+func (o PollOption) Title() string {
+	return strings.Join(o.Nicks, ", ")
+}
+
+// pollState is the room's own record of a poll, including every vote.
+type pollState struct {
+	id        string
+	question  string
+	options   []string
+	creator   string // Session ID of whoever started the poll
+	anonymous bool
+	closed    bool
+	closedAt  time.Time
+	expires   time.Time
+
+	// votes maps each voter's session ID to their vote
+	votes map[string]pollVote
+}
+
+// pollVote is the option someone voted for, and their nick when they did
+// for showing who voted.
+type pollVote struct {
+	option int
+	nick   string
+}
+
+func (p *pollState) view() *Poll {
+	poll := &Poll{
+		ID:        p.id,
+		Question:  p.question,
+		Options:   make([]PollOption, len(p.options)),
+		Total:     len(p.votes),
+		Anonymous: p.anonymous,
+		Closed:    p.closed,
+		Expires:   p.expires,
+	}
+
+	for i, text := range p.options {
+		poll.Options[i] = PollOption{Number: i + 1, Text: text}
+	}
+
+	for _, vote := range p.votes {
+		poll.Options[vote.option].Votes++
+		if !p.anonymous {
+			poll.Options[vote.option].Nicks = append(poll.Options[vote.option].Nicks, vote.nick)
+		}
+	}
+
+	for i := range poll.Options {
+		slices.Sort(poll.Options[i].Nicks)
+		if poll.Total > 0 {
+			poll.Options[i].Percent = poll.Options[i].Votes * 100 / poll.Total
+		}
+	}
+
+	return poll
+}
+
+// option finds an option by its number or text.
+func (p *pollState) option(option string) (int, bool) {
+	if n, err := strconv.Atoi(option); err == nil {
+		return n - 1, n >= 1 && n <= len(p.options)
+	}
+
+	i := slices.IndexFunc(p.options, func(text string) bool {
+		return strings.EqualFold(text, option)
+	})
+
+	return i, i >= 0
+}
+
+// parsePoll splits /poll arguments into the question, options and flags.
+func parsePoll(args []string) (*pollState, error) {
+	poll := &pollState{
+		votes: make(map[string]pollVote),
+	}
+
+	var texts []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--anonymous":
+			poll.anonymous = true
+		case "--duration":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing value for --duration")
+			}
+			i++
+
+			duration, err := time.ParseDuration(args[i])
+			if err != nil || duration <= 0 || duration > pollMaxDuration {
+				return nil, fmt.Errorf("invalid duration: %s (up to %s)", args[i], pollMaxDuration)
+			}
+			poll.expires = time.Now().Add(duration)
+		default:
+			texts = append(texts, args[i])
+		}
+	}
+
+	if len(texts) == 0 || strings.TrimSpace(texts[0]) == "" {
+		return nil, fmt.Errorf("a poll needs a question")
+	}
+
+	poll.question, poll.options = texts[0], texts[1:]
+
+	if len(poll.options) < pollMinOptions || len(poll.options) > pollMaxOptions {
+		return nil, fmt.Errorf("a poll needs between %d and %d options", pollMinOptions, pollMaxOptions)
+	}
+
+	for i, option := range poll.options {
+		if strings.TrimSpace(option) == "" || slices.Contains(poll.options[:i], option) {
+			return nil, fmt.Errorf("poll options must be distinct and not empty")
+		}
+	}
+
+	return poll, nil
+}
+
+func (r *Room) startPoll(client *Client, data ClientDataExternal, args []string) {
+	fail := func(body string) {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	if data.Nick == "" {
+		fail(roomErrNoNick.Error())
+		return
+	}
+
+	poll, err := parsePoll(args)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	texts := append([]string{poll.question}, poll.options...)
+	for i, text := range texts {
+		if texts[i], err = r.moderate(client, data, text); err != nil {
+			r.reject(ClientMessage{Client: client}, err)
+			return
+		}
+	}
+	poll.question, poll.options = texts[0], texts[1:]
+
+	poll.id = messageID()
+	poll.creator = client.ID
+	r.polls[poll.id] = poll
+
+	r.enqueue(RoomMessage{
+		ID:    poll.id,
+		Type:  MessageTypePoll,
+		Nick:  data.Nick,
+		Color: data.Color,
+		Body:  poll.question,
+		Poll:  poll.view(),
+		From:  NewSender(client, data),
+	}.Fill())
+}
+
+func (r *Room) vote(client *Client, data ClientDataExternal, id string, option string) {
+	fail := func(body string) {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	if data.Nick == "" {
+		fail(roomErrNoNick.Error())
+		return
+	}
+
+	poll, ok := r.polls[id]
+	if !ok {
+		fail(fmt.Sprintf("no poll with id %s", id))
+		return
+	}
+
+	if poll.closed {
+		fail("this poll is closed")
+		return
+	}
+
+	i, ok := poll.option(option)
+	if !ok {
+		fail(fmt.Sprintf("no option %s in this poll", option))
+		return
+	}
+
+	vote := pollVote{option: i, nick: data.Nick}
+	if previous, voted := poll.votes[client.ID]; voted && previous == vote {
+		return
+	}
+
+	poll.votes[client.ID] = vote
+	r.updatePoll(poll)
+}
+
+// closePoll closes a poll, or the latest open poll if id is empty. Only
+// the poll's creator or an admin can close it.
+func (r *Room) closePoll(client *Client, data ClientDataExternal, id string) {
+	fail := func(body string) {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	var poll *pollState
+	if id != "" {
+		poll = r.polls[id]
+	} else {
+		for _, test := range r.polls {
+			if !test.closed && (poll == nil || test.id > poll.id) {
+				poll = test
+			}
+		}
+	}
+
+	if poll == nil || poll.closed {
+		fail("no open poll to close")
+		return
+	}
+
+	if poll.creator != client.ID && data.OPLevel < OPLevelAdmin {
+		fail(fmt.Sprintf("insufficient permission (%s) to close another user's poll (%s)", data.OPLevel, OPLevelAdmin))
+		return
+	}
+
+	r.endPoll(poll, fmt.Sprintf("%s closed the poll", data.Nick))
+}
+
+// expirePolls ends polls whose time is up or whose message has dropped
+// out of the room's history, and forgets polls that closed a while ago.
+func (r *Room) expirePolls(now time.Time) {
+	for id, poll := range r.polls {
+		switch {
+		case poll.closed:
+			if now.Sub(poll.closedAt) > pollRetention {
+				delete(r.polls, id)
+			}
+		case !poll.expires.IsZero() && now.After(poll.expires):
+			r.endPoll(poll, "the poll has ended")
+		case r.messageIndex(id) < 0:
+			r.endPoll(poll, "the poll has ended")
+		}
+	}
+}
+
+// endPoll closes a poll and announces its results.
+func (r *Room) endPoll(poll *pollState, reason string) {
+	poll.closed = true
+	poll.closedAt = time.Now()
+	view := r.updatePoll(poll)
+
+	lines := make([]string, 0, len(view.Options)+1)
+	lines = append(lines, fmt.Sprintf("%s: %s (%d votes)", reason, view.Question, view.Total))
+	for _, option := range view.Options {
+		lines = append(lines, fmt.Sprintf("  %s: %d (%d%%)", option.Text, option.Votes, option.Percent))
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeNotice,
+		Body: strings.Join(lines, "\n"),
+	}.Fill())
+}
+
+// updatePoll refreshes the poll in the room's history and pushes the new
+// tally to the room.
+func (r *Room) updatePoll(poll *pollState) *Poll {
+	view := poll.view()
+
+	if i := r.messageIndex(poll.id); i >= 0 {
+		r.history[i].Poll = view
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeVotes,
+		Poll: view,
+	}.Fill())
+
+	return view
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePoll(t *testing.T) {
+	tests := []struct {
+		args      []string
+		question  string
+		options   []string
+		anonymous bool
+		expires   bool
+		wantErr   bool
+	}{
+		{args: []string{"lunch?", "pizza", "soup"}, question: "lunch?", options: []string{"pizza", "soup"}},
+		{args: []string{"--anonymous", "lunch?", "pizza", "soup"}, question: "lunch?", options: []string{"pizza", "soup"}, anonymous: true},
+		{args: []string{"lunch?", "pizza", "--duration", "10m", "soup"}, question: "lunch?", options: []string{"pizza", "soup"}, expires: true},
+		{args: []string{"lunch?", "pizza"}, wantErr: true},
+		{args: []string{"lunch?", "pizza", "pizza"}, wantErr: true},
+		{args: []string{"lunch?", "pizza", " "}, wantErr: true},
+		{args: []string{" ", "pizza", "soup"}, wantErr: true},
+		{args: []string{"--anonymous"}, wantErr: true},
+		{args: []string{"lunch?", "pizza", "soup", "--duration"}, wantErr: true},
+		{args: []string{"lunch?", "pizza", "soup", "--duration", "soon"}, wantErr: true},
+		{args: []string{"lunch?", "pizza", "soup", "--duration", "48h"}, wantErr: true},
+		{args: []string{"lunch?", "pizza", "soup", "--duration", "-1m"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		poll, err := parsePoll(test.args)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parsePoll(%q) error = %v, want error %t", test.args, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if poll.question != test.question || !slices.Equal(poll.options, test.options) {
+			t.Errorf("parsePoll(%q) = %q %q, want %q %q", test.args, poll.question, poll.options, test.question, test.options)
+		}
+		if poll.anonymous != test.anonymous {
+			t.Errorf("parsePoll(%q) anonymous = %t, want %t", test.args, poll.anonymous, test.anonymous)
+		}
+		if expires := !poll.expires.IsZero(); expires != test.expires {
+			t.Errorf("parsePoll(%q) expires = %t, want %t", test.args, expires, test.expires)
+		}
+	}
+}
+
+func TestParsePollMaxOptions(t *testing.T) {
+	options := make([]string, pollMaxOptions)
+	for i := range options {
+		options[i] = fmt.Sprintf("option%d", i+1)
+	}
+
+	// The most arguments /poll can take must get through ParseCommand
+	input := fmt.Sprintf("/poll question %s --duration 10m --anonymous", strings.Join(options, " "))
+	command, err := ParseCommand(input)
+	if err != nil {
+		t.Fatalf("ParseCommand(%q) error = %v", input, err)
+	}
+
+	poll, err := parsePoll(command.Args)
+	if err != nil {
+		t.Fatalf("parsePoll(%q) error = %v", command.Args, err)
+	}
+
+	if len(poll.options) != pollMaxOptions || !poll.anonymous || time.Until(poll.expires) <= 0 {
+		t.Errorf("parsePoll(%q) = %+v, want every option and flag", command.Args, poll)
+	}
+}
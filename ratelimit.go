@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+const (
+	rateLimitBurst     = 5
+	rateLimitPerSecond = 1.0
+)
+
+type tokenBucket struct {
+	tokens float64
+	burst  float64
+	rate   float64
+	last   time.Time
+}
+
+func newTokenBucket(burst int, rate float64) *tokenBucket {
+	return &tokenBucket{
+		tokens: float64(burst),
+		burst:  float64(burst),
+		rate:   rate,
+	}
+}
+
+func (b *tokenBucket) Allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
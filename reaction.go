@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	reactionMaxLength     = 8
+	reactionMaxPerMessage = 20
+)
+
+func validReaction(emoji string) bool {
+	if emoji == "" || utf8.RuneCountInString(emoji) > reactionMaxLength {
+		return false
+	}
+
+	for _, c := range emoji {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// react adds or removes the client's reaction on a message in the room's
+// history and pushes the updated reactions to the room.
+func (r *Room) react(client *Client, data ClientDataExternal, id string, emoji string, add bool) {
+	fail := func(body string) {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	if data.Nick == "" {
+		fail(roomErrNoNick.Error())
+		return
+	}
+
+	i := r.messageIndex(id)
+	if i < 0 || !r.history[i].Type.IsChat() {
+		fail(fmt.Sprintf("no message with id %s", id))
+		return
+	}
+
+	if !validReaction(emoji) {
+		fail(fmt.Sprintf("invalid reaction: %s", emoji))
+		return
+	}
+
+	reactions := r.history[i].ReactionsOrEmpty()
+	items := slices.Clone(reactions.Items)
+
+	j := slices.IndexFunc(items, func(reaction Reaction) bool {
+		return reaction.Emoji == emoji
+	})
+
+	if add {
+		if j < 0 {
+			if len(items) >= reactionMaxPerMessage {
+				fail("this message has too many different reactions")
+				return
+			}
+
+			items = append(items, Reaction{Emoji: emoji})
+			j = len(items) - 1
+		}
+
+		if slices.Contains(items[j].ids, client.ID) {
+			return
+		}
+
+		items[j].ids = append(slices.Clone(items[j].ids), client.ID)
+		items[j].Nicks = append(slices.Clone(items[j].Nicks), data.Nick)
+	} else {
+		k := -1
+		if j >= 0 {
+			k = slices.Index(items[j].ids, client.ID)
+		}
+		if k < 0 {
+			fail(fmt.Sprintf("you haven't reacted with %s", emoji))
+			return
+		}
+
+		items[j].ids = slices.Delete(slices.Clone(items[j].ids), k, k+1)
+		items[j].Nicks = slices.Delete(slices.Clone(items[j].Nicks), k, k+1)
+
+		if len(items[j].ids) == 0 {
+			items = slices.Delete(items, j, j+1)
+		}
+	}
+
+	reactions.Items = items
+	r.history[i].Reactions = &reactions
+
+	r.enqueue(RoomMessage{
+		Type:      MessageTypeReactions,
+		Reactions: &reactions,
+	}.Fill())
+}
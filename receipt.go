@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// historyReplay is how many recent messages a client is sent on joining.
+const historyReplay = 50
+
+// DeliveryState tracks the last message a room delivered to a user. The
+// room can't tell whether they read it, only that it was sent to them.
+type DeliveryState struct {
+	Nick          string
+	LastDelivered string
+	DeliveredAt   time.Time
+	SpokeAt       time.Time
+}
+
+// deliveryState returns the delivery state for a client, keyed by its
+// session so it survives reconnecting. Bots and API identities have none.
+func (r *Room) deliveryState(client *Client) *DeliveryState {
+	if client == nil || client.Bot != nil || client.ID == "" {
+		return nil
+	}
+
+	state, ok := r.deliveries[client.ID]
+	if !ok {
+		state = &DeliveryState{}
+		r.deliveries[client.ID] = state
+	}
+
+	if nick := r.Clients[client].Nick; nick != "" {
+		state.Nick = nick
+	}
+
+	return state
+}
+
+func (r *Room) markDelivered(client *Client, message RoomMessage) {
+	if state := r.deliveryState(client); state != nil {
+		state.LastDelivered = message.ID
+		state.DeliveredAt = time.Now()
+	}
+}
+
+func (r *Room) markSpoke(client *Client) {
+	if state := r.deliveryState(client); state != nil {
+		state.SpokeAt = time.Now()
+	}
+}
+
+// replay sends a joining client the room's recent history, with a divider
+// after the last message they were sent if there's anything new since.
+func (r *Room) replay(client *Client) error {
+	history, _ := r.History("", historyReplay)
+	if len(history) == 0 {
+		return nil
+	}
+
+	var lastDelivered string
+	if state := r.deliveryState(client); state != nil {
+		lastDelivered = state.LastDelivered
+	}
+
+	for i, message := range history {
+		if !Ignores.Ignoring(client.ID, message) {
+			if err := r.send(client, message); err != nil {
+				return err
+			}
+		}
+
+		if message.ID == lastDelivered && i < len(history)-1 {
+			err := r.send(client, RoomMessage{
+				Type: MessageTypeDivider,
+				Body: "new messages",
+				Target: Target{
+					Type:   TargetTypeOne,
+					Client: client,
+				},
+			}.Fill())
+			if err != nil {
+				return err
+			}
+		}
+
+		// Stop if the client was evicted for falling behind
+		if _, ok := r.Clients[client]; !ok {
+			return nil
+		}
+	}
+
+	r.markDelivered(client, history[len(history)-1])
+	return nil
+}
+
+func (r *Room) seen(client *Client, nick string) {
+	var body string
+
+	var state *DeliveryState
+	for _, test := range r.deliveries {
+		if test.Nick == nick && (state == nil || test.DeliveredAt.After(state.DeliveredAt)) {
+			state = test
+		}
+	}
+
+	online := false
+	for _, data := range r.Clients {
+		if data.Nick == nick {
+			online = true
+			break
+		}
+	}
+
+	switch {
+	case state == nil && online:
+		body = fmt.Sprintf("%s is here now", nick)
+	case state == nil:
+		body = fmt.Sprintf("%s hasn't been seen in this room", nick)
+	default:
+		parts := make([]string, 0, 3)
+		if online {
+			parts = append(parts, "is here now")
+		}
+		if !state.SpokeAt.IsZero() {
+			parts = append(parts, fmt.Sprintf("last spoke %s ago", time.Since(state.SpokeAt).Round(time.Second)))
+		} else {
+			parts = append(parts, "hasn't spoken")
+		}
+		if !state.DeliveredAt.IsZero() {
+			parts = append(parts, fmt.Sprintf("last received a message %s ago", time.Since(state.DeliveredAt).Round(time.Second)))
+		}
+		body = fmt.Sprintf("%s %s", nick, strings.Join(parts, ", "))
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeCommand,
+		Body: body,
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: client,
+		},
+	}.Fill())
+}
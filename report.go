@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	reportsSize   = 200
+	reportContext = 2 // messages either side of a reported message
+	reportRecent  = 5 // recent messages from a reported nick
+)
+
+// Report is a user's complaint about someone in the room, with the
+// messages around it at the time.
+type Report struct {
+	ID        int
+	Time      time.Time
+	Reporter  string
+	Nick      string
+	MessageID string
+	Reason    string
+	Context   []RoomMessage
+
+	Resolved   bool
+	ResolvedBy string
+	Resolution string
+}
+
+func (r Report) String() string {
+	subject := r.Nick
+	if r.MessageID != "" {
+		subject = fmt.Sprintf("%s (message %s)", r.Nick, r.MessageID)
+	}
+
+	line := fmt.Sprintf("#%d [%s] %s reported %s: %s", r.ID, r.Time.Format("15:04:05"), r.Reporter, subject, r.Reason)
+	if r.Resolved {
+		line += fmt.Sprintf(" (resolved by %s: %s)", r.ResolvedBy, r.Resolution)
+	}
+	return line
+}
+
+func (r *Room) report(message ClientMessage, data ClientDataExternal, subject string, reason string) {
+	if data.Nick == "" {
+		r.reject(message, roomErrNoNick)
+		return
+	}
+
+	if !r.allow(message) {
+		r.reject(message, roomErrRateLimited)
+		return
+	}
+
+	report := &Report{
+		Time:     time.Now(),
+		Reporter: data.Nick,
+		Reason:   reason,
+	}
+
+	if i := r.messageIndex(subject); i >= 0 && r.history[i].Type.IsChat() {
+		report.Nick = r.history[i].Nick
+		report.MessageID = subject
+		report.Context = slices.Clone(r.history[max(i-reportContext, 0):min(i+reportContext+1, len(r.history))])
+	} else {
+		for i := len(r.history) - 1; i >= 0 && len(report.Context) < reportRecent; i-- {
+			if r.history[i].Nick == subject && r.history[i].Type.IsChat() {
+				report.Context = append(report.Context, r.history[i])
+			}
+		}
+		slices.Reverse(report.Context)
+
+		if len(report.Context) == 0 && !slices.Contains(r.Nicks(), subject) {
+			r.reject(message, fmt.Errorf("no user or message %s to report", subject))
+			return
+		}
+		report.Nick = subject
+	}
+
+	if report.Nick == data.Nick {
+		r.reject(message, errors.New("you can't report yourself"))
+		return
+	}
+
+	r.reportID++
+	report.ID = r.reportID
+	r.reports = append(r.reports, report)
+	if len(r.reports) > reportsSize {
+		r.reports = r.reports[len(r.reports)-reportsSize:]
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeCommand,
+		Body: fmt.Sprintf("thanks, your report (#%d) has been sent to the room's moderators", report.ID),
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: message.Client,
+		},
+	}.Fill())
+
+	for client, other := range r.Clients {
+		if client.Bot != nil || other.OPLevel < OPLevelAdmin {
+			continue
+		}
+
+		r.enqueue(RoomMessage{
+			Type: MessageTypeWhisper,
+			Body: fmt.Sprintf("new report %s\n  /reports to review, /resolve %d [dismiss|mute|kick] [note] to act", report, report.ID),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+}
+
+// listReports shows moderators the open reports, or every report.
+func (r *Room) listReports(client *Client, all bool) {
+	var lines []string
+	for _, report := range r.reports {
+		if report.Resolved && !all {
+			continue
+		}
+
+		lines = append(lines, "  "+report.String())
+		for _, context := range report.Context {
+			marker := " "
+			if context.ID == report.MessageID {
+				marker = ">"
+			}
+			lines = append(lines, fmt.Sprintf("    %s [%s] %s: %s", marker, context.Time.Format("15:04:05"), context.Nick, NewReply(context).Snippet))
+		}
+	}
+
+	body := "no open reports"
+	if len(lines) > 0 {
+		body = "reports:\n" + strings.Join(lines, "\n")
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeCommand,
+		Body: body,
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: client,
+		},
+	}.Fill())
+}
+
+// resolve closes a report, optionally muting or kicking the reported user.
+func (r *Room) resolve(client *Client, data ClientDataExternal, args []string) error {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	i := slices.IndexFunc(r.reports, func(report *Report) bool {
+		return report.ID == id
+	})
+	if err != nil || i < 0 {
+		reply(MessageTypeError, fmt.Sprintf("no report with id %s", args[0]))
+		return nil
+	}
+
+	report := r.reports[i]
+	if report.Resolved {
+		reply(MessageTypeError, fmt.Sprintf("report #%d is already resolved", report.ID))
+		return nil
+	}
+
+	action, note := "dismiss", ""
+	if len(args) > 1 {
+		action = args[1]
+	}
+	if len(args) > 2 {
+		note = args[2]
+	}
+
+	var quit error
+	switch action {
+	case "dismiss":
+	case "mute", "kick":
+		var target *Client
+		for other, otherData := range r.Clients {
+			if otherData.Nick == report.Nick {
+				target = other
+				break
+			}
+		}
+
+		if target == nil {
+			reply(MessageTypeError, fmt.Sprintf("%s is no longer in the room, use /resolve %d dismiss", report.Nick, report.ID))
+			return nil
+		}
+
+		reason := fmt.Sprintf("following report #%d", report.ID)
+		if action == "mute" {
+			r.mute(muteKey(ClientMessage{Client: target}), report.Nick, filterMuteDuration, fmt.Sprintf("by %s %s", data.Nick, reason))
+		} else {
+			quit = r.kick(client, data, report.Nick, reason)
+		}
+	default:
+		reply(MessageTypeError, fmt.Sprintf("unknown action: %s (use dismiss, mute or kick)", action))
+		return nil
+	}
+
+	report.Resolved = true
+	report.ResolvedBy = data.Nick
+	report.Resolution = action
+	if note != "" {
+		report.Resolution = fmt.Sprintf("%s, %s", action, note)
+	}
+
+	reply(MessageTypeCommand, fmt.Sprintf("resolved report #%d (%s)", report.ID, report.Resolution))
+
+	return quit
+}
@@ -1,15 +1,35 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	roomErrShouldQuit = errors.New("room should quit")
+
+	roomErrMessagesDisabled = errors.New("messages are disabled on this room")
+	roomErrNoNick           = errors.New("you must set a nickname with /nick before sending messages")
+	roomErrRateLimited      = errors.New("you are sending messages too quickly")
 )
 
+// historySize is how many broadcast messages a room remembers.
+const historySize = 1000
+
+// QueryRequest runs Fn on the room's goroutine, so it can safely read or
+// modify room state, and closes Done when finished.
+type QueryRequest struct {
+	Fn   func(r *Room)
+	Done chan struct{}
+}
+
 type Room struct {
 	Name string
 
@@ -20,8 +40,58 @@ type Room struct {
 
 	Register   chan RegisterRequest
 	Unregister chan UnregisterRequest
+	Queries    chan QueryRequest
 
 	Rules *Rules
+
+	history  []RoomMessage
+	pending  []RoomMessage
+	webhooks []*Webhook
+	hooks    []*IncomingHook
+	limits   map[any]*tokenBucket
+	closed   chan struct{}
+
+	// mentionLimits rate limits notifying people outside the room
+	mentionLimits map[any]*tokenBucket
+
+	uploads     string
+	uploadBytes int64
+
+	typing       map[*Client]time.Time
+	typingLimits map[*Client]*tokenBucket
+	deliveries   map[string]*DeliveryState
+	polls        map[string]*pollState
+
+	mutes      map[any]time.Time
+	filterHits map[any]int
+
+	auditLog []AuditEntry
+
+	reports  []*Report
+	reportID int
+
+	// closing is set when a server admin closes the room
+	closing bool
+
+	// lingerUntil keeps the room open after its last user disconnects, so
+	// they can resume it
+	lingerUntil time.Time
+
+	// trace logs every event in the room's loop
+	trace bool
+
+	// beat is when the loop last came round, in Unix nanoseconds
+	beat atomic.Int64
+
+	// stalled is set while the watchdog considers the loop stuck
+	stalled atomic.Bool
+
+	// replaced is the room that took over after a restart, or the room
+	// itself once its loop has exited, so only one of the two happens
+	replaced atomic.Pointer[Room]
+
+	// saved is the room's state as of the last tick, for restarts
+	saved atomic.Pointer[roomState]
 }
 
 func NewRoom(name string) *Room {
@@ -35,15 +105,111 @@ func NewRoom(name string) *Room {
 
 		Register:   make(chan RegisterRequest, 256),
 		Unregister: make(chan UnregisterRequest, 256),
+		Queries:    make(chan QueryRequest, 64),
 
 		Rules: NewRules(),
+
+		limits: make(map[any]*tokenBucket),
+		closed: make(chan struct{}),
+
+		mentionLimits: make(map[any]*tokenBucket),
+
+		typing:       make(map[*Client]time.Time),
+		typingLimits: make(map[*Client]*tokenBucket),
+		deliveries:   make(map[string]*DeliveryState),
+		trace:        slices.Contains(config.Log.Trace, name),
+		polls:        make(map[string]*pollState),
+
+		mutes:      make(map[any]time.Time),
+		filterHits: make(map[any]int),
 	}
 }
 
+// Query runs fn on the room's goroutine and waits for it to finish. It
+// returns false if the room has closed.
+func (r *Room) Query(fn func(r *Room)) bool {
+	done := make(chan struct{})
+
+	select {
+	case r.Queries <- QueryRequest{Fn: fn, Done: done}:
+	case <-r.closed:
+		return false
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-r.closed:
+		return false
+	}
+}
+
+// History returns broadcast messages older than the message with ID
+// before (or the newest if before is empty), oldest first, up to limit.
+// It reports false if there's no message with that ID.
+func (r *Room) History(before string, limit int) ([]RoomMessage, bool) {
+	end := len(r.history)
+	if before != "" {
+		end = r.messageIndex(before)
+		if end < 0 {
+			return nil, false
+		}
+	}
+
+	start := max(end-limit, 0)
+
+	history := make([]RoomMessage, end-start)
+	copy(history, r.history[start:end])
+	return history, true
+}
+
 func (r *Room) Run() {
+	ticker := time.NewTicker(time.Second)
+
+	defer func() {
+		ticker.Stop()
+
+		// A restarted room's state belongs to its replacement
+		if !r.replaced.CompareAndSwap(nil, r) {
+			r.logger().Warn("stuck room loop exited")
+			return
+		}
+
+		for _, hook := range r.webhooks {
+			hook.Close()
+		}
+		for _, hook := range r.hooks {
+			IncomingHooks.Delete(hook.Token)
+		}
+		r.removeUploads()
+		close(r.closed)
+		r.logger().Info("room closed")
+	}()
+
+	r.logger().Info("room opened")
+
 	for {
+		if r.shouldQuit(r.drain()) {
+			return
+		}
+
+		if r.replaced.Load() != nil {
+			return
+		}
+		r.beat.Store(time.Now().UnixNano())
+
 		select {
 		case req := <-r.Register:
+			r.traceEvent("register", clientAttr(req.Client, req.WantsNick))
+
+			if _, ok := r.Clients[req.Client]; ok && req.Restored {
+				continue
+			}
+
+			if !req.Sent.IsZero() {
+				Metrics.Registration.Observe(time.Since(req.Sent).Seconds())
+			}
+
 			data := NewClientDataExternal(req.Creator)
 
 			r.Clients[req.Client] = data
@@ -52,27 +218,63 @@ func (r *Room) Run() {
 				r.setNick(req.Client, req.WantsNick)
 			}
 
+			greeting := []RoomMessage{
+				RoomMessage{
+					Type: MessageTypeRoom,
+					Body: r.Name,
+				}.Fill(),
+				r.pinsMessage(),
+			}
+
 			if r.Rules.hasWelcomeMessage {
-				req.Client.Send <- RoomMessage{
+				greeting = append(greeting, RoomMessage{
 					Type: MessageTypeNotice,
 					Body: r.Rules.welcomeMessage,
-				}.Fill()
+				}.Fill())
+			}
+
+			if r.Rules.topic != "" {
+				greeting = append(greeting, RoomMessage{
+					Type: MessageTypeNotice,
+					Body: fmt.Sprintf("topic: %s", r.Rules.topic),
+				}.Fill())
+			}
+
+			if err := r.greet(req.Client, greeting); r.shouldQuit(err) {
+				return
+			}
+
+			level := slog.LevelInfo
+			if req.Client.Bot != nil {
+				level = slog.LevelDebug
 			}
+			r.logger().Log(context.Background(), level, "client joined", clientAttr(req.Client, r.Clients[req.Client].Nick))
 
 		case req := <-r.Unregister:
+			r.traceEvent("unregister", clientAttr(req.Client, r.Clients[req.Client].Nick), "reason", req.Reason)
+
 			data, ok := r.Clients[req.Client]
 			if !ok {
 				continue
 			}
 
+			r.logger().Info("client left", clientAttr(req.Client, data.Nick), "reason", req.Reason)
+
+			if req.Disconnected {
+				r.lingerUntil = time.Now().Add(resumeWindow)
+			}
+
 			if data.Nick != "" {
-				r.Internal <- RoomMessage{
+				message := RoomMessage{
 					Type: MessageTypeLeave,
 					Body: fmt.Sprintf("%s left the room: %s", data.Nick, req.Reason),
 					Target: Target{
 						Type: TargetTypeAll,
 					},
 				}.Fill()
+
+				r.enqueue(message)
+				r.emit(WebhookEventLeave, message)
 			}
 
 			err := r.remove(req.Client)
@@ -80,22 +282,57 @@ func (r *Room) Run() {
 				return
 			}
 
+		case req := <-r.Queries:
+			r.traceEvent("query")
+
+			req.Fn(r)
+			close(req.Done)
+
+			if r.closing {
+				return
+			}
+
+		case now := <-ticker.C:
+			r.expirePolls(now)
+			r.expireMutes(now)
+			r.checkpoint()
+
+			err := r.expireTyping(now)
+			if r.shouldQuit(err) {
+				return
+			}
+
+			if !r.lingerUntil.IsZero() && r.deserted(now) {
+				r.unlist()
+				return
+			}
+
 		case message := <-r.Internal:
+			r.traceEvent("internal", "type", message.Type, "id", message.ID, "target", message.Target.Type, "nick", message.Nick)
+
 			err := r.handleInternal(message)
 			if r.shouldQuit(err) {
 				return
 			}
 
 		case message := <-r.External:
+			if r.trace {
+				args := []any{"type", message.Type}
+				if message.Client != nil {
+					args = append(args, clientAttr(message.Client, r.Clients[message.Client].Nick))
+				}
+				if message.Command != nil {
+					args = append(args, "command", message.Command.Name)
+				}
+				r.traceEvent("external", args...)
+			}
+
 			if r.Rules.noMessages {
-				r.Internal <- RoomMessage{
-					Type: MessageTypeError,
-					Body: "messages are disabled on this room",
-					Target: Target{
-						Type:   TargetTypeOne,
-						Client: message.Client,
-					},
-				}.Fill()
+				// Typing frames are sent without the user doing anything, so
+				// there's no one to tell
+				if message.Type != MessageTypeTyping {
+					r.reject(message, roomErrMessagesDisabled)
+				}
 				continue
 			}
 
@@ -116,62 +353,296 @@ func (r *Room) shouldQuit(err error) bool {
 }
 
 func (r *Room) remove(client *Client) error {
+	if data, ok := r.Clients[client]; ok && data.Nick != "" {
+		Directory.Remove(data.Nick, client)
+	}
+
 	delete(r.Clients, client)
-	if !r.Rules.keepOpen && len(r.Clients) == 0 {
-		Rooms.Delete(r.Name)
+	delete(r.limits, client)
+	delete(r.mentionLimits, client)
+	delete(r.typing, client)
+	delete(r.typingLimits, client)
+	if r.deserted(time.Now()) {
+		r.unlist()
 		return roomErrShouldQuit
 	}
 
 	return nil
 }
 
+// deserted reports whether the room has no one left in it to stay open
+// for.
+func (r *Room) deserted(now time.Time) bool {
+	return !r.Rules.keepOpen && r.humans() == 0 && !now.Before(r.lingerUntil)
+}
+
+// unlist removes the room from Rooms, unless a restart replaced it there.
+func (r *Room) unlist() {
+	Rooms.Mu.Lock()
+	defer Rooms.Mu.Unlock()
+
+	if Rooms.M[r.Name] == r {
+		delete(Rooms.M, r.Name)
+	}
+}
+
+// humans counts the clients in the room that aren't bots.
+func (r *Room) humans() int {
+	n := 0
+	for client := range r.Clients {
+		if client.Bot == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// greet sends a joining client the room's details and recent history. A
+// client that can't keep up is removed like on any other send.
+func (r *Room) greet(client *Client, messages []RoomMessage) error {
+	for _, message := range messages {
+		if err := r.send(client, message); err != nil {
+			return err
+		}
+
+		if _, ok := r.Clients[client]; !ok {
+			return nil
+		}
+	}
+
+	return r.replay(client)
+}
+
+// enqueue queues a message for the room to handle once it's finished with
+// the current event. Code running on the room's goroutine uses it rather
+// than Internal, which would block the room forever if it filled up.
+func (r *Room) enqueue(message RoomMessage) {
+	r.pending = append(r.pending, message)
+}
+
+// drain handles the messages queued while handling the last event,
+// including any they queue in turn.
+func (r *Room) drain() error {
+	for len(r.pending) > 0 {
+		message := r.pending[0]
+		r.pending = r.pending[1:]
+
+		r.traceEvent("queued", "type", message.Type, "id", message.ID, "target", message.Target.Type, "nick", message.Nick)
+
+		if err := r.handleInternal(message); errors.Is(err, roomErrShouldQuit) {
+			return err
+		}
+	}
+
+	r.pending = nil
+	return nil
+}
+
 func (r *Room) send(client *Client, message RoomMessage) error {
 	select {
 	case client.Send <- message:
 		return nil
 	default:
+		Metrics.SlowConsumers.Add(1)
+		r.logger().Warn("evicted slow consumer", clientAttr(client, r.Clients[client].Nick))
 		return r.remove(client)
 	}
 }
 
+// record adds broadcast messages to the room's history, reporting whether
+// the message was recorded.
+func (r *Room) record(message RoomMessage) bool {
+	switch message.Type {
+	case MessageTypeReset, MessageTypeRoom, MessageTypeThread, MessageTypeReactions, MessageTypeDivider, MessageTypePins, MessageTypeVotes:
+		return false
+	}
+
+	if message.Target.Type != TargetTypeAll {
+		return false
+	}
+
+	r.history = append(r.history, message)
+	if len(r.history) > historySize {
+		r.history = r.history[len(r.history)-historySize:]
+	}
+
+	return true
+}
+
+// post checks a message from a client or identity and broadcasts it to
+// the room, optionally as a reply.
+func (r *Room) post(message ClientMessage, replyTo *Reply) error {
+	data := r.sender(message)
+
+	if data.Nick == "" {
+		r.reject(message, roomErrNoNick)
+		return nil
+	}
+
+	if !r.allow(message) {
+		r.reject(message, roomErrRateLimited)
+		return nil
+	}
+
+	if r.muted(message) {
+		r.reject(message, roomErrMuted)
+		return nil
+	}
+
+	body, err := r.filter(message, data)
+	if err != nil {
+		r.reject(message, err)
+		return nil
+	}
+	message.Body = body
+
+	local, remote, all := r.mentions(message.Body)
+	if all && data.OPLevel < OPLevelAdmin {
+		r.reject(message, roomErrMentionAll)
+		return nil
+	}
+
+	if err := r.setTyping(message.Client, false); errors.Is(err, roomErrShouldQuit) {
+		return roomErrShouldQuit
+	}
+
+	r.markSpoke(message.Client)
+
+	promoted := message.Promote(data)
+	promoted.ReplyTo = replyTo
+	promoted.Mentions = local
+	promoted.MentionAll = all
+	r.formatMessage(&promoted)
+	r.accept(message)
+	r.emit(WebhookEventMessage, promoted)
+
+	err = r.handleInternal(promoted)
+	if errors.Is(err, roomErrShouldQuit) {
+		return roomErrShouldQuit
+	}
+
+	if len(remote) > 0 {
+		r.notifyMentions(message, promoted, remote)
+	}
+
+	return nil
+}
+
+// message finds a message in the room's history by ID.
+func (r *Room) message(id string) (RoomMessage, bool) {
+	i := r.messageIndex(id)
+	if i < 0 {
+		return RoomMessage{}, false
+	}
+
+	return r.history[i], true
+}
+
+func (r *Room) messageIndex(id string) int {
+	for i := len(r.history) - 1; i >= 0; i-- {
+		if r.history[i].ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// reject reports why a message from a client or identity wasn't accepted,
+// either to its Reply channel or back to the client.
+func (r *Room) reject(message ClientMessage, err error) {
+	Metrics.Errors.Inc(errorKind(err))
+
+	if message.Client != nil {
+		r.logger().Debug("message rejected", clientAttr(message.Client, r.Clients[message.Client].Nick), "error", err)
+	} else if message.Identity != nil {
+		r.logger().Debug("message rejected", "identity", message.Identity.Nick, "error", err)
+	}
+
+	if message.Reply != nil {
+		message.Reply <- err
+		return
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeError,
+		Body: err.Error(),
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: message.Client,
+		},
+	}.Fill())
+}
+
+func (r *Room) accept(message ClientMessage) {
+	if message.Reply != nil {
+		message.Reply <- nil
+	}
+}
+
+// senderKey identifies the sender of a message for rate limits and mutes.
+func senderKey(message ClientMessage) any {
+	if message.Identity != nil {
+		return "identity:" + message.Identity.Nick
+	}
+	return message.Client
+}
+
+// allow applies the room's rate limit to the sender of a message.
+func (r *Room) allow(message ClientMessage) bool {
+	key := senderKey(message)
+
+	bucket, ok := r.limits[key]
+	if !ok {
+		bucket = newTokenBucket(rateLimitBurst, rateLimitPerSecond)
+		r.limits[key] = bucket
+	}
+
+	return bucket.Allow(time.Now())
+}
+
+// sender returns the room's data on the sender of a message.
+func (r *Room) sender(message ClientMessage) ClientDataExternal {
+	if message.Identity != nil {
+		return *message.Identity
+	}
+
+	return r.Clients[message.Client]
+}
+
 func (r *Room) handleInternal(message RoomMessage) error {
+	recorded := r.record(message)
+
 	for client, data := range r.Clients {
-		if !message.Target.Should(client, data) {
+		if !message.Target.Should(client, data) || Ignores.Ignoring(client.ID, message) {
 			continue
 		}
 
+		message := message
+		message.ForYou = message.MentionsNick(data.Nick)
+
 		err := r.send(client, message)
 		if errors.Is(err, roomErrShouldQuit) {
 			return roomErrShouldQuit
 		}
+
+		if recorded {
+			r.markDelivered(client, message)
+		}
 	}
 
 	return nil
 }
 
 func (r *Room) handleExternal(message ClientMessage) error {
-	switch message.Type {
-	case MessageTypeMessage:
-		data := r.Clients[message.Client]
-
-		if data.Nick == "" {
-			r.Internal <- RoomMessage{
-				Type: MessageTypeError,
-				Body: "you must set a nickname with /nick before sending messages",
-				Target: Target{
-					Type:   TargetTypeOne,
-					Client: message.Client,
-				},
-			}.Fill()
-			return nil
-		}
+	Metrics.Messages.Inc(string(message.Type))
 
-		err := r.handleInternal(message.Promote(data))
-		if errors.Is(err, roomErrShouldQuit) {
-			return roomErrShouldQuit
-		}
+	switch message.Type {
+	case MessageTypeMessage, MessageTypeBot:
+		return r.post(message, nil)
 
-		return nil
+	case MessageTypeTyping:
+		return r.setTyping(message.Client, message.Typing)
 
 	case MessageTypeCommand:
 		if message.Command == nil {
@@ -179,88 +650,233 @@ func (r *Room) handleExternal(message ClientMessage) error {
 		}
 
 		if r.Rules.noCommands {
-			r.Internal <- RoomMessage{
+			r.enqueue(RoomMessage{
 				Type: MessageTypeError,
 				Body: "commands are disabled on this room",
 				Target: Target{
 					Type:   TargetTypeOne,
 					Client: message.Client,
 				},
-			}.Fill()
+			}.Fill())
+			return nil
 		}
 
 		command := message.Command
 
-		data := r.Clients[message.Client]
+		data := r.sender(message)
 
 		if command.OPLevel > data.OPLevel {
-			r.Internal <- RoomMessage{
+			Metrics.Errors.Inc("permission")
+			r.logger().Warn("permission denied", "command", command.Name, "nick", data.Nick, "level", data.OPLevel.String())
+			r.enqueue(RoomMessage{
 				Type: MessageTypeError,
 				Body: fmt.Sprintf("insufficient permission (%s) to use %s (%s)", data.OPLevel, command.Name, command.OPLevel),
 				Target: Target{
 					Type:   TargetTypeOne,
 					Client: message.Client,
 				},
-			}.Fill()
+			}.Fill())
 			return nil
 		}
 
-		switch command.Name {
-		case "nick":
-			r.setNick(message.Client, command.Args[0])
-		case "who":
-			r.getWho(message.Client)
-		case "w":
-			r.whisper(message.Client, command.Args[0], command.Args[1])
-		case "op":
-			r.op(message.Client, command.Args[0], command.Args[1])
-		case "welcome":
-			var welcomeMessage *string
-			if len(command.Args) > 0 {
-				welcomeMessage = &command.Args[0]
+		Metrics.Commands.Inc(command.Name)
+
+		// Audit once the command has run, so the log shows whether it worked
+		var entry *AuditEntry
+		if audited(command, data.OPLevel) && command.Name != "audit" {
+			entry = r.auditEntry(message, data, command)
+		}
+
+		queued := len(r.pending)
+		err := r.runCommand(message, data, command)
+
+		failure, failed := commandError(message.Client, r.pending[queued:])
+		if failed {
+			r.logger().Info("command failed", clientAttr(message.Client, data.Nick), "command", command.Name, "error", failure)
+		}
+
+		if entry != nil {
+			entry.Outcome = auditOutcomeOK
+			if failed {
+				entry.Outcome = "failed: " + failure
 			}
+			r.audit(*entry)
+		}
+
+		return err
+
+	default:
+		return nil
+	}
+}
+
+// runCommand runs a room command the sender has permission to use.
+func (r *Room) runCommand(message ClientMessage, data ClientDataExternal, command *Command) error {
+	switch command.Name {
+	case "nick":
+		r.setNick(message.Client, command.Args[0])
+	case "who":
+		r.getWho(message.Client)
+	case "w", "msg":
+		body, err := r.moderate(message.Client, data, command.Args[1])
+		if err != nil {
+			r.reject(message, err)
+			return nil
+		}
+
+		if command.Name == "w" {
+			r.whisper(message.Client, command.Args[0], body)
+		} else {
+			r.directMessage(message.Client, data, command.Args[0], body)
+		}
+	case "dms":
+		var with *string
+		if len(command.Args) > 0 {
+			with = &command.Args[0]
+		}
+
+		return r.dms(message.Client, data, with)
+	case "ignore":
+		r.ignore(message.Client, data, command.Args)
+	case "unignore":
+		r.unignore(message.Client, command.Args[0])
+	case "ignores":
+		r.listIgnores(message.Client)
+	case "report":
+		r.report(message, data, command.Args[0], command.Args[1])
+	case "reports":
+		r.listReports(message.Client, len(command.Args) > 0 && command.Args[0] == "all")
+	case "resolve":
+		return r.resolve(message.Client, data, command.Args)
+	case "audit":
+		var n *string
+		if len(command.Args) > 0 {
+			n = &command.Args[0]
+		}
 
-			r.welcome(message.Client, welcomeMessage)
-		case "password":
-			var password *string
-			if len(command.Args) > 0 {
-				password = &command.Args[0]
+		r.showAudit(message.Client, n)
+	case "filter":
+		r.filterCommand(message.Client, command.Args)
+	case "mute", "unmute":
+		var duration *string
+		if len(command.Args) > 1 {
+			duration = &command.Args[1]
+		}
+
+		r.muteCommand(message.Client, data, command.Args[0], duration, command.Name == "mute")
+	case "op":
+		r.op(message.Client, command.Args[0], command.Args[1])
+	case "welcome":
+		var welcomeMessage *string
+		if len(command.Args) > 0 {
+			welcomeMessage = &command.Args[0]
+		}
+
+		r.welcome(message.Client, welcomeMessage)
+	case "password":
+		var password *string
+		if len(command.Args) > 0 {
+			password = &command.Args[0]
+		}
+
+		r.password(message.Client, password)
+	case "topic":
+		var topic *string
+		if len(command.Args) > 0 {
+			text, err := r.moderate(message.Client, data, command.Args[0])
+			if err != nil {
+				r.reject(message, err)
+				return nil
 			}
+			topic = &text
+		}
 
-			r.password(message.Client, password)
+		r.setTopic(message.Client, data, topic)
+	case "me":
+		return r.post(message.As(MessageTypeAction, command.Args[0]), nil)
+	case "notice":
+		return r.post(message.As(MessageTypeNotice, command.Args[0]), nil)
+	case "shrug", "tableflip", "unflip":
+		body := textFaces[command.Name]
+		if len(command.Args) > 0 {
+			body = fmt.Sprintf("%s %s", command.Args[0], body)
 		}
 
-		return nil
+		return r.post(message.As(MessageTypeMessage, body), nil)
+	case "reply":
+		return r.reply(message, command.Args[0], command.Args[1])
+	case "thread":
+		r.thread(message.Client, command.Args[0])
+	case "seen":
+		r.seen(message.Client, command.Args[0])
+	case "poll":
+		if command.Args[0] == "close" {
+			var id string
+			if len(command.Args) > 1 {
+				id = command.Args[1]
+			}
+			r.closePoll(message.Client, data, id)
+		} else {
+			r.startPoll(message.Client, data, command.Args)
+		}
+	case "vote":
+		r.vote(message.Client, data, command.Args[0], command.Args[1])
+	case "pin":
+		r.pin(message.Client, data, command.Args[0])
+	case "unpin":
+		r.unpin(message.Client, data, command.Args[0])
+	case "pins":
+		r.listPins(message.Client)
+	case "react":
+		r.react(message.Client, data, command.Args[0], command.Args[1], true)
+	case "unreact":
+		r.react(message.Client, data, command.Args[0], command.Args[1], false)
+	case "kick":
+		var reason string
+		if len(command.Args) > 1 {
+			reason = command.Args[1]
+		}
 
+		return r.kick(message.Client, data, command.Args[0], reason)
+	case "webhook":
+		r.webhook(message.Client, command.Args)
+	case "hook":
+		r.hook(message.Client, command.Args)
+	case "formatting":
+		r.formatting(message.Client, command.Args[0])
 	default:
-		return nil
+		if command.Target == CommandTargetBot {
+			r.botCommand(message, data)
+		}
 	}
+
+	return nil
 }
 
 func (r *Room) setNick(client *Client, nick string) {
 	newNick := strings.TrimSpace(nick)
 	if newNick == "" {
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeError,
 			Body: "nickname cannot be empty",
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
 		return
 	}
 
 	for other, data := range r.Clients {
 		if other != client && data.Nick == newNick {
-			r.Internal <- RoomMessage{
+			r.enqueue(RoomMessage{
 				Type: MessageTypeError,
 				Body: fmt.Sprintf("nickname %s is already in use", newNick),
 				Target: Target{
 					Type:   TargetTypeOne,
 					Client: client,
 				},
-			}.Fill()
+			}.Fill())
 			return
 		}
 	}
@@ -270,16 +886,29 @@ func (r *Room) setNick(client *Client, nick string) {
 	data.Nick = newNick
 	r.Clients[client] = data
 
+	if client.Bot == nil {
+		if oldNick != "" {
+			Directory.Remove(oldNick, client)
+		}
+		Directory.Add(newNick, client, r)
+	}
+
 	if oldNick != "" {
-		r.Internal <- RoomMessage{
+		message := RoomMessage{
 			Type: MessageTypeNotice,
 			Body: fmt.Sprintf("%s changed their nickname to %s", oldNick, newNick),
 		}.Fill()
+
+		r.enqueue(message)
+		r.emit(WebhookEventNick, message)
 	} else {
-		r.Internal <- RoomMessage{
+		message := RoomMessage{
 			Type: MessageTypeJoin,
 			Body: fmt.Sprintf("%s joined the room", newNick),
 		}.Fill()
+
+		r.enqueue(message)
+		r.emit(WebhookEventJoin, message)
 	}
 }
 
@@ -291,7 +920,7 @@ func (r *Room) getWho(client *Client) {
 			continue
 		}
 
-		online = append(online, fmt.Sprintf("%s (%s)", data.Nick, client.Conn.RemoteAddr().String()))
+		online = append(online, fmt.Sprintf("%s (%s)", data.Nick, client.Addr))
 	}
 
 	var body string
@@ -301,14 +930,14 @@ func (r *Room) getWho(client *Client) {
 		body = fmt.Sprintf("currently online:\n%s", strings.Join(online, "\n"))
 	}
 
-	r.Internal <- RoomMessage{
+	r.enqueue(RoomMessage{
 		Type: MessageTypeCommand,
 		Body: body,
 		Target: Target{
 			Type:   TargetTypeOne,
 			Client: client,
 		},
-	}.Fill()
+	}.Fill())
 }
 
 func (r *Room) whisper(client *Client, nick string, message string) {
@@ -318,18 +947,30 @@ func (r *Room) whisper(client *Client, nick string, message string) {
 	}
 
 	if data.Nick == "" {
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeError,
 			Body: "you must set a nickname before sending messages",
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
 		return
 	}
 
-	r.Internal <- RoomMessage{
+	if !slices.Contains(r.Nicks(), nick) {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: fmt.Sprintf("%s is not in this room, use /msg to message them directly", nick),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+		return
+	}
+
+	r.enqueue(RoomMessage{
 		Type:  MessageTypeWhisper,
 		Nick:  data.Nick,
 		Color: data.Color,
@@ -338,9 +979,10 @@ func (r *Room) whisper(client *Client, nick string, message string) {
 			Type: TargetTypeNickOne,
 			Nick: nick,
 		},
-	}.Fill()
+		From: NewSender(client, data),
+	}.Fill())
 
-	r.Internal <- RoomMessage{
+	r.enqueue(RoomMessage{
 		Type:  MessageTypeWhisper,
 		Nick:  data.Nick,
 		Color: data.Color,
@@ -349,20 +991,20 @@ func (r *Room) whisper(client *Client, nick string, message string) {
 			Type:   TargetTypeOne,
 			Client: client,
 		},
-	}.Fill()
+	}.Fill())
 }
 
 func (r *Room) op(client *Client, nick string, levelName string) {
 	level, err := ParseOPLevel(levelName)
 	if err != nil {
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeError,
 			Body: err.Error(),
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
 		return
 	}
 
@@ -375,86 +1017,211 @@ func (r *Room) op(client *Client, nick string, levelName string) {
 	}
 
 	if target == nil {
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeError,
 			Body: fmt.Sprintf("user %s is not online", nick),
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
 		return
 	}
 
-	data := r.Clients[client]
+	data := r.Clients[target]
 	data.OPLevel = level
-	r.Clients[client] = data
+	r.Clients[target] = data
 
-	r.Internal <- RoomMessage{
+	r.enqueue(RoomMessage{
 		Type: MessageTypeNotice,
 		Body: fmt.Sprintf("%s's permission level is now %s", nick, level),
 		Target: Target{
 			Type:   TargetTypeOne,
 			Client: client,
 		},
-	}.Fill()
+	}.Fill())
 
-	r.Internal <- RoomMessage{
+	r.enqueue(RoomMessage{
 		Type: MessageTypeNotice,
 		Body: fmt.Sprintf("your permission level is now %s", level),
 		Target: Target{
 			Type:   TargetTypeOne,
 			Client: target,
 		},
-	}.Fill()
+	}.Fill())
 }
 
 func (r *Room) welcome(client *Client, message *string) {
-	if *message == "" {
+	if message == nil || *message == "" {
 		r.Rules.hasWelcomeMessage = false
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeNotice,
 			Body: "welcome message disabled",
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
 	} else {
 		r.Rules.hasWelcomeMessage = true
 		r.Rules.welcomeMessage = *message
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeNotice,
 			Body: fmt.Sprintf("welcome message set to: %s", *message),
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
 	}
 }
 
 func (r *Room) password(client *Client, password *string) {
 	if password == nil {
 		r.Rules.hasPassword = false
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeNotice,
 			Body: "password disabled",
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
 	} else {
 		r.Rules.hasPassword = true
 		r.Rules.password = *password
-		r.Internal <- RoomMessage{
+		r.enqueue(RoomMessage{
 			Type: MessageTypeNotice,
 			Body: fmt.Sprintf("password set to: %s", *password),
 			Target: Target{
 				Type:   TargetTypeOne,
 				Client: client,
 			},
-		}.Fill()
+		}.Fill())
+	}
+}
+
+func (r *Room) setTopic(client *Client, data ClientDataExternal, topic *string) {
+	if topic == nil {
+		if r.Rules.topic == "" {
+			r.enqueue(RoomMessage{
+				Type: MessageTypeCommand,
+				Body: "no topic is set",
+				Target: Target{
+					Type:   TargetTypeOne,
+					Client: client,
+				},
+			}.Fill())
+		} else {
+			r.enqueue(RoomMessage{
+				Type: MessageTypeCommand,
+				Body: fmt.Sprintf("topic: %s", r.Rules.topic),
+				Target: Target{
+					Type:   TargetTypeOne,
+					Client: client,
+				},
+			}.Fill())
+		}
+		return
+	}
+
+	if data.OPLevel < OPLevelAdmin {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: fmt.Sprintf("insufficient permission (%s) to change the topic (%s)", data.OPLevel, OPLevelAdmin),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+		return
+	}
+
+	r.Rules.topic = *topic
+
+	body := fmt.Sprintf("%s changed the topic to: %s", data.Nick, *topic)
+	if *topic == "" {
+		body = fmt.Sprintf("%s cleared the topic", data.Nick)
+	}
+
+	message := RoomMessage{
+		Type: MessageTypeNotice,
+		Body: body,
+	}.Fill()
+
+	r.enqueue(message)
+	r.emit(WebhookEventTopic, message)
+}
+
+// Nicks returns the nicknames of everyone in the room who has one.
+func (r *Room) Nicks() []string {
+	nicks := make([]string, 0, len(r.Clients))
+	for _, data := range r.Clients {
+		if data.Nick != "" {
+			nicks = append(nicks, data.Nick)
+		}
+	}
+
+	sort.Strings(nicks)
+	return nicks
+}
+
+// kick removes the user with the given nick from the room and sends them
+// back to the lobby.
+func (r *Room) kick(client *Client, data ClientDataExternal, nick string, reason string) error {
+	var target *Client
+	for test, testData := range r.Clients {
+		if testData.Nick == nick {
+			target = test
+			break
+		}
+	}
+
+	if target == nil {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: fmt.Sprintf("user %s is not online", nick),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+		return nil
+	}
+
+	r.logger().Info("client kicked", clientAttr(target, nick), "by", data.Nick, "reason", reason)
+
+	body := fmt.Sprintf("%s was kicked by %s", nick, data.Nick)
+	if reason != "" {
+		body = fmt.Sprintf("%s: %s", body, reason)
+	}
+
+	message := RoomMessage{
+		Type: MessageTypeLeave,
+		Body: body,
+	}.Fill()
+
+	// Tell the target before they're removed, so they see why
+	_ = r.send(target, message)
+
+	err := r.remove(target)
+
+	r.enqueue(message)
+	r.emit(WebhookEventKick, message)
+
+	sendExit(target)
+
+	return err
+}
+
+// sendExit sends a client back to the lobby from outside its goroutine.
+func sendExit(client *Client) {
+	select {
+	case client.Recv <- ClientMessage{
+		Type:    MessageTypeCommand,
+		Client:  client,
+		Command: &Command{Name: "exit", Target: CommandTargetClient},
+	}:
+	default:
 	}
 }
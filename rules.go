@@ -1,5 +1,7 @@
 package main
 
+import "encoding/json"
+
 type Rules struct {
 	hasPassword bool
 	password    string
@@ -7,9 +9,16 @@ type Rules struct {
 	hasWelcomeMessage bool
 	welcomeMessage    string
 
-	noCommands bool
-	noMessages bool
-	keepOpen   bool
+	topic string
+	pins  []Pin
+
+	filters  []Filter
+	filterID int
+
+	noCommands   bool
+	noMessages   bool
+	noFormatting bool
+	keepOpen     bool
 }
 
 func NewRules() *Rules {
@@ -33,6 +42,12 @@ func (r *Rules) WelcomeMessage(message string) *Rules {
 	return r
 }
 
+func (r *Rules) Topic(topic string) *Rules {
+	r.topic = topic
+
+	return r
+}
+
 func (r *Rules) NoCommands() *Rules {
 	r.noCommands = true
 
@@ -45,8 +60,99 @@ func (r *Rules) NoMessages() *Rules {
 	return r
 }
 
+func (r *Rules) NoFormatting() *Rules {
+	r.noFormatting = true
+
+	return r
+}
+
 func (r *Rules) KeepOpen() *Rules {
 	r.keepOpen = true
 
 	return r
 }
+
+// RulesJSON is the API representation of a room's rules. The password
+// itself is never exposed.
+type RulesJSON struct {
+	HasPassword    bool     `json:"has_password"`
+	WelcomeMessage *string  `json:"welcome_message"`
+	Topic          string   `json:"topic"`
+	Pins           []Pin    `json:"pins"`
+	Filters        []Filter `json:"filters"`
+	NoCommands     bool     `json:"no_commands"`
+	NoMessages     bool     `json:"no_messages"`
+	NoFormatting   bool     `json:"no_formatting"`
+	KeepOpen       bool     `json:"keep_open"`
+}
+
+func (r *Rules) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.View())
+}
+
+// View returns the rules as they're shown to the API and admins.
+func (r *Rules) View() RulesJSON {
+	view := RulesJSON{
+		HasPassword:  r.hasPassword,
+		Topic:        r.topic,
+		Pins:         r.pins,
+		Filters:      r.filters,
+		NoCommands:   r.noCommands,
+		NoMessages:   r.noMessages,
+		NoFormatting: r.noFormatting,
+		KeepOpen:     r.keepOpen,
+	}
+
+	if r.hasWelcomeMessage {
+		view.WelcomeMessage = &r.welcomeMessage
+	}
+
+	return view
+}
+
+// RulesUpdate is a partial update to a room's rules; nil fields are left
+// unchanged. Empty strings clear the password and welcome message.
+type RulesUpdate struct {
+	Password       *string `json:"password"`
+	WelcomeMessage *string `json:"welcome_message"`
+	Topic          *string `json:"topic"`
+	NoCommands     *bool   `json:"no_commands"`
+	NoMessages     *bool   `json:"no_messages"`
+	NoFormatting   *bool   `json:"no_formatting"`
+}
+
+func (r *Rules) Apply(update RulesUpdate) {
+	if update.Password != nil {
+		r.hasPassword = *update.Password != ""
+		r.password = *update.Password
+	}
+
+	if update.WelcomeMessage != nil {
+		r.hasWelcomeMessage = *update.WelcomeMessage != ""
+		r.welcomeMessage = *update.WelcomeMessage
+	}
+
+	if update.Topic != nil {
+		r.topic = *update.Topic
+	}
+
+	if update.NoCommands != nil {
+		r.noCommands = *update.NoCommands
+	}
+
+	if update.NoMessages != nil {
+		r.noMessages = *update.NoMessages
+	}
+
+	if update.NoFormatting != nil {
+		r.noFormatting = *update.NoFormatting
+	}
+}
+
+// Reset restores the default rules, keeping rooms that are held open
+// (such as the lobby) open. Pinned messages are kept.
+func (r *Rules) Reset() {
+	keepOpen, pins := r.keepOpen, r.pins
+	*r = *NewRules()
+	r.keepOpen, r.pins = keepOpen, pins
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const sessionCookieName = "chat_session"
+
+// Sessions maps a session's streams to the clients serving them, so that
+// transports without a bidirectional connection can find their client.
+// Each page opens its own stream, so tabs sharing a session cookie don't
+// send as each other.
+var Sessions = NewMuMap[string, *Client]()
+
+// streamMaxLength bounds the stream IDs pages can pick.
+const streamMaxLength = 64
+
+// resumeWindow is how long a disconnected session is put back where it
+// was when it reconnects, e.g. after an event stream drops.
+const resumeWindow = 2 * time.Minute
+
+type resumePoint struct {
+	Room *Room
+	Name string
+	Nick string
+	Time time.Time
+}
+
+type resumes struct {
+	mu     sync.Mutex
+	points map[string]resumePoint
+}
+
+// Resumes remembers the room and nick of recently disconnected sessions.
+var Resumes = &resumes{
+	points: make(map[string]resumePoint),
+}
+
+func (r *resumes) Save(id string, room *Room, nick string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for other, point := range r.points {
+		if now.Sub(point.Time) > resumeWindow {
+			delete(r.points, other)
+		}
+	}
+
+	r.points[id] = resumePoint{Room: room, Nick: nick, Time: now}
+}
+
+// Take returns where a session was if it left recently. The point's Room
+// is nil if the room has since closed.
+func (r *resumes) Take(id string) (resumePoint, bool) {
+	r.mu.Lock()
+	point, ok := r.points[id]
+	delete(r.points, id)
+	r.mu.Unlock()
+
+	if !ok || time.Since(point.Time) > resumeWindow {
+		return resumePoint{}, false
+	}
+
+	// Follow the room through restarts
+	for {
+		next := point.Room.replaced.Load()
+		if next == nil || next == point.Room {
+			break
+		}
+		point.Room = next
+	}
+
+	Rooms.Mu.RLock()
+	defer Rooms.Mu.RUnlock()
+
+	for name, room := range Rooms.M {
+		if room == point.Room {
+			point.Name = name
+			return point, true
+		}
+	}
+
+	point.Room = nil
+	return point, true
+}
+
+func sessionID(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+
+	return cookie.Value, true
+}
+
+// streamKey identifies one of a session's streams in Sessions.
+func streamKey(id string, stream string) string {
+	return id + "/" + stream
+}
+
+// streamParam returns the stream ID a page picked for its connection and
+// sends back with its requests. Pages that don't pick one share a single
+// stream per session.
+func streamParam(r *http.Request) (string, bool) {
+	stream := r.URL.Query().Get("stream")
+	return stream, len(stream) <= streamMaxLength
+}
+
+func newSessionCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    randomToken(16),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ensureSession returns the request's session ID, issuing a new session
+// cookie on the response if the request doesn't carry one.
+func ensureSession(w http.ResponseWriter, r *http.Request) string {
+	if id, ok := sessionID(r); ok {
+		return id
+	}
+
+	cookie := newSessionCookie()
+	http.SetCookie(w, cookie)
+	return cookie.Value
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
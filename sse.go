@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type sseTransport struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+	cancel  context.CancelFunc
+	addr    string
+	json    bool
+}
+
+// ReadFrame blocks until the stream is closed, since input arrives
+// separately through SendHandler.
+func (t *sseTransport) ReadFrame() (WSFrame, error) {
+	<-t.ctx.Done()
+	return WSFrame{}, t.ctx.Err()
+}
+
+func (t *sseTransport) WriteMessage(msg RoomMessage) error {
+	var data string
+	if t.json {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	} else {
+		data = msg.Render()
+	}
+
+	// Each line of the payload must be sent as its own data field
+	var buf strings.Builder
+	buf.WriteString("event: message\n")
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: ")
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	if _, err := fmt.Fprint(t.w, buf.String()); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+
+	return nil
+}
+
+func (t *sseTransport) RemoteAddr() string {
+	return t.addr
+}
+
+// Close ends the stream, e.g. when an admin disconnects the client.
+func (t *sseTransport) Close() error {
+	t.cancel()
+	return nil
+}
+
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported.", http.StatusInternalServerError)
+		return
+	}
+
+	stream, ok := streamParam(r)
+	if !ok {
+		http.Error(w, "Invalid stream.", http.StatusBadRequest)
+		return
+	}
+
+	id := ensureSession(w, r)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	client := NewClient(id, &sseTransport{
+		w:       w,
+		flusher: flusher,
+		ctx:     ctx,
+		cancel:  cancel,
+		addr:    r.RemoteAddr,
+		json:    r.URL.Query().Get("format") == "json",
+	})
+	client.Stream = stream
+	client.Serve()
+}
+
+func SendHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionID(r)
+	if !ok {
+		http.Error(w, "No session.", http.StatusUnauthorized)
+		return
+	}
+
+	stream, _ := streamParam(r)
+	client, ok := Sessions.Get(streamKey(id, stream))
+	if !ok {
+		http.Error(w, "No active stream for session.", http.StatusConflict)
+		return
+	}
+
+	var frame WSFrame
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&frame); err != nil {
+			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
+			return
+		}
+	} else {
+		frame.Message = r.FormValue("message")
+	}
+
+	message, ok := frame.ClientMessage(client)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	select {
+	case client.Recv <- message:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Too many pending messages.", http.StatusServiceUnavailable)
+	}
+}
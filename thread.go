@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (r *Room) reply(message ClientMessage, id string, body string) error {
+	parent, ok := r.message(id)
+	if !ok || !parent.Type.IsChat() {
+		r.reject(message, fmt.Errorf("no message with id %s", id))
+		return nil
+	}
+
+	replyTo := NewReply(parent)
+	count := len(r.replies(replyTo.Root))
+
+	err := r.post(message.As(MessageTypeMessage, body), replyTo)
+	if errors.Is(err, roomErrShouldQuit) {
+		return roomErrShouldQuit
+	}
+
+	// Nothing to update if the reply was rejected
+	if replies := len(r.replies(replyTo.Root)); replies != count {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeThread,
+			Thread: &Thread{
+				Root:    replyTo.Root,
+				Replies: replies,
+			},
+		}.Fill())
+	}
+
+	return nil
+}
+
+// replies returns every message in the thread rooted at id, oldest first.
+func (r *Room) replies(id string) []RoomMessage {
+	var replies []RoomMessage
+	for _, message := range r.history {
+		if message.ReplyTo != nil && message.ReplyTo.Root == id {
+			replies = append(replies, message)
+		}
+	}
+
+	return replies
+}
+
+func (r *Room) thread(client *Client, id string) {
+	root, ok := r.message(id)
+	if ok && root.ReplyTo != nil {
+		root, ok = r.message(root.ReplyTo.Root)
+	}
+
+	if !ok {
+		r.enqueue(RoomMessage{
+			Type: MessageTypeError,
+			Body: fmt.Sprintf("no message with id %s", id),
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+		return
+	}
+
+	replies := r.replies(root.ID)
+
+	lines := make([]string, 0, len(replies)+1)
+	lines = append(lines, fmt.Sprintf("thread (%d replies):", len(replies)))
+	lines = append(lines, fmt.Sprintf("[%s] %s: %s", root.Time.Format("15:04:05"), root.Nick, root.Body))
+	for _, reply := range replies {
+		lines = append(lines, fmt.Sprintf("  [%s] %s: %s", reply.Time.Format("15:04:05"), reply.Nick, reply.Body))
+	}
+
+	r.enqueue(RoomMessage{
+		Type: MessageTypeCommand,
+		Body: strings.Join(lines, "\n"),
+		Target: Target{
+			Type:   TargetTypeOne,
+			Client: client,
+		},
+	}.Fill())
+}
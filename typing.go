@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// typingTimeout is how long someone counts as typing without another
+// typing frame.
+const typingTimeout = 6 * time.Second
+
+// Starting to type is rate limited per client, since each start and stop
+// is broadcast to the whole room.
+const (
+	typingRateBurst     = 5
+	typingRatePerSecond = 0.5
+)
+
+// setTyping records whether a client is typing, broadcasting the change if
+// it affects who's shown as typing.
+func (r *Room) setTyping(client *Client, typing bool) error {
+	if client == nil {
+		return nil
+	}
+
+	data, ok := r.Clients[client]
+	if !ok || data.Nick == "" {
+		return nil
+	}
+
+	_, was := r.typing[client]
+
+	if typing && !was {
+		bucket, ok := r.typingLimits[client]
+		if !ok {
+			bucket = newTokenBucket(typingRateBurst, typingRatePerSecond)
+			r.typingLimits[client] = bucket
+		}
+
+		if !bucket.Allow(time.Now()) {
+			return nil
+		}
+	}
+
+	if typing {
+		r.typing[client] = time.Now()
+	} else {
+		delete(r.typing, client)
+	}
+
+	if was == typing {
+		return nil
+	}
+
+	return r.broadcastTyping()
+}
+
+// expireTyping drops anyone who hasn't sent a typing frame recently.
+func (r *Room) expireTyping(now time.Time) error {
+	changed := false
+	for client, since := range r.typing {
+		if now.Sub(since) > typingTimeout {
+			delete(r.typing, client)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return r.broadcastTyping()
+}
+
+// broadcastTyping sends everyone the list of who else is typing. Typing
+// updates are ephemeral, so they skip the room's history.
+func (r *Room) broadcastTyping() error {
+	for client := range r.Clients {
+		if client.Bot != nil {
+			continue
+		}
+
+		nicks := make([]string, 0, len(r.typing))
+		for typist := range r.typing {
+			if nick := r.Clients[typist].Nick; typist != client && nick != "" {
+				nicks = append(nicks, nick)
+			}
+		}
+		slices.Sort(nicks)
+
+		err := r.send(client, RoomMessage{
+			Type:   MessageTypeTyping,
+			Body:   typingBody(nicks),
+			Typing: nicks,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+		if errors.Is(err, roomErrShouldQuit) {
+			return roomErrShouldQuit
+		}
+	}
+
+	return nil
+}
+
+func typingBody(nicks []string) string {
+	switch len(nicks) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing…", nicks[0])
+	case 2, 3:
+		return fmt.Sprintf("%s and %s are typing…", strings.Join(nicks[:len(nicks)-1], ", "), nicks[len(nicks)-1])
+	default:
+		return "several people are typing…"
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	thumbnailSize      = 320
+	thumbnailMaxPixels = 24 << 20
+	uploadTimeout      = 5 * time.Second
+)
+
+// thumbnailDecodes bounds how many images are decoded at once, since each
+// can take up to a few hundred MB.
+var thumbnailDecodes = make(chan struct{}, 2)
+
+var errUploadQuota = errors.New("the room's upload quota is used up")
+
+type Attachment struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	Type         string `json:"type"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+
+	// thumbnailBytes is the size of the thumbnail, counted against the
+	// room's quota along with the file
+	thumbnailBytes int64
+}
+
+func (a *Attachment) HumanSize() string {
+	switch {
+	case a.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	case a.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(a.Size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", a.Size)
+	}
+}
+
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.Type, "image/")
+}
+
+func UploadHandler(w http.ResponseWriter, r *http.Request) {
+	room, ok := Rooms.Get(roomParam(r))
+	if !ok {
+		http.Error(w, "Room not found.", http.StatusNotFound)
+		return
+	}
+
+	id, ok := sessionID(r)
+	if !ok {
+		http.Error(w, "No session.", http.StatusUnauthorized)
+		return
+	}
+
+	stream, _ := streamParam(r)
+	client, ok := Sessions.Get(streamKey(id, stream))
+	if !ok {
+		http.Error(w, "No active connection for session.", http.StatusConflict)
+		return
+	}
+
+	var member bool
+	var dir string
+	ok = room.Query(func(room *Room) {
+		data, in := room.Clients[client]
+		member = in && data.Nick != ""
+		dir = room.uploadDir()
+	})
+	if !ok || !member {
+		http.Error(w, "You must be in the room with a nickname to upload.", http.StatusForbidden)
+		return
+	}
+
+	limits := config.Uploads
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxSize+(1<<20))
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing or oversized file.", http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if header.Size > limits.MaxSize {
+		http.Error(w, fmt.Sprintf("Files can be at most %d bytes.", limits.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Trust what the file looks like, not what the client says it is
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if !limits.Allows(contentType) {
+		http.Error(w, fmt.Sprintf("Files of type %s aren't allowed.", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+		return
+	}
+
+	var reserved bool
+	ok = room.Query(func(room *Room) {
+		reserved = room.reserveUpload(header.Size)
+	})
+	if !ok {
+		http.Error(w, "Room is closed.", http.StatusGone)
+		return
+	}
+	if !reserved {
+		http.Error(w, errUploadQuota.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
+	attachment, err := saveUpload(dir, file, header.Filename, header.Size, contentType)
+	if err != nil {
+		room.Query(func(room *Room) {
+			room.reserveUpload(-header.Size)
+		})
+		http.Error(w, "Failed to store file.", http.StatusInternalServerError)
+		return
+	}
+
+	// The file was reserved up front, but its thumbnail only has a size
+	// now; go without one rather than fail if it doesn't fit
+	if attachment.thumbnailBytes > 0 {
+		reserved = false
+		room.Query(func(room *Room) {
+			reserved = room.reserveUpload(attachment.thumbnailBytes)
+		})
+		if !reserved {
+			_ = os.Remove(filepath.Join(config.Uploads.Dir, dir, filepath.Base(attachment.ThumbnailURL)))
+			attachment.ThumbnailURL = ""
+			attachment.thumbnailBytes = 0
+		}
+	}
+
+	reply := make(chan error, 1)
+	room.External <- ClientMessage{
+		Type:       MessageTypeMessage,
+		Client:     client,
+		Body:       r.FormValue("caption"),
+		Attachment: attachment,
+		Reply:      reply,
+	}
+
+	var postErr error
+	select {
+	case postErr = <-reply:
+	case <-time.After(uploadTimeout):
+		postErr = errors.New("room did not respond")
+	}
+
+	if postErr != nil {
+		removeUpload(dir, attachment)
+		room.Query(func(room *Room) {
+			room.reserveUpload(-header.Size - attachment.thumbnailBytes)
+		})
+		http.Error(w, postErr.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func saveUpload(dir string, file io.Reader, name string, size int64, contentType string) (*Attachment, error) {
+	if err := os.MkdirAll(filepath.Join(config.Uploads.Dir, dir), 0o755); err != nil {
+		return nil, err
+	}
+
+	ext := ""
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		ext = exts[0]
+	}
+
+	stored := randomToken(8) + ext
+	path := filepath.Join(config.Uploads.Dir, dir, stored)
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	written, err := io.Copy(out, io.LimitReader(file, size))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	attachment := &Attachment{
+		Name: filepath.Base(name),
+		Size: written,
+		Type: contentType,
+		URL:  fmt.Sprintf("%s/uploads/%s/%s", config.PublicURL, dir, stored),
+	}
+
+	if attachment.IsImage() {
+		if thumb, size, err := saveThumbnail(path, contentType); err == nil {
+			attachment.ThumbnailURL = fmt.Sprintf("%s/uploads/%s/%s", config.PublicURL, dir, thumb)
+			attachment.thumbnailBytes = size
+		}
+	}
+
+	return attachment, nil
+}
+
+func removeUpload(dir string, attachment *Attachment) {
+	for _, url := range []string{attachment.URL, attachment.ThumbnailURL} {
+		if url != "" {
+			_ = os.Remove(filepath.Join(config.Uploads.Dir, dir, filepath.Base(url)))
+		}
+	}
+}
+
+// saveThumbnail writes a scaled down copy of an image next to it, returning
+// the thumbnail's file name and size.
+func saveThumbnail(path string, contentType string) (string, int64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	// Check the size first, so huge images can't exhaust memory
+	cfg, _, err := image.DecodeConfig(in)
+	if err != nil {
+		return "", 0, err
+	}
+	if cfg.Width*cfg.Height > thumbnailMaxPixels {
+		return "", 0, errors.New("image too large to thumbnail")
+	}
+
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	thumbnailDecodes <- struct{}{}
+	defer func() {
+		<-thumbnailDecodes
+	}()
+
+	src, _, err := image.Decode(in)
+	if err != nil {
+		return "", 0, err
+	}
+
+	thumb := scaleDown(src, thumbnailSize)
+
+	ext := ".png"
+	if contentType == "image/jpeg" {
+		ext = ".jpg"
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".thumb" + ext
+	out, err := os.Create(filepath.Join(filepath.Dir(path), name))
+	if err != nil {
+		return "", 0, err
+	}
+
+	if ext == ".jpg" {
+		err = jpeg.Encode(out, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(out, thumb)
+	}
+
+	var size int64
+	if info, statErr := out.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return name, size, err
+}
+
+// scaleDown shrinks an image to fit within size by size pixels, using
+// nearest-neighbour sampling. Smaller images are returned as is.
+func scaleDown(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			dst.Set(x, y, src.At(bounds.Min.X+x*w/tw, bounds.Min.Y+y*h/th))
+		}
+	}
+
+	return dst
+}
+
+// UploadsHandler serves uploaded files. Anything that isn't an image is
+// served as a download, so uploads can't run in the page's origin.
+func UploadsHandler(w http.ResponseWriter, r *http.Request) {
+	dir, name := chi.URLParam(r, "dir"), chi.URLParam(r, "file")
+	if dir == "" || name == "" || strings.ContainsAny(dir+name, `/\`) || strings.HasPrefix(dir, ".") || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(config.Uploads.Dir, dir, name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; sandbox")
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); !strings.HasPrefix(contentType, "image/") || contentType == "image/svg+xml" {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// uploadDir returns the directory, relative to the configured upload
+// directory, that holds the room's files.
+func (r *Room) uploadDir() string {
+	if r.uploads == "" {
+		r.uploads = randomToken(8)
+	}
+
+	return r.uploads
+}
+
+// reserveUpload counts size bytes against the room's upload quota, or
+// releases them if size is negative.
+func (r *Room) reserveUpload(size int64) bool {
+	if size > 0 && r.uploadBytes+size > config.Uploads.RoomQuota {
+		return false
+	}
+
+	r.uploadBytes += size
+	return true
+}
+
+// removeUploads deletes all of the room's files.
+func (r *Room) removeUploads() {
+	if r.uploads != "" {
+		_ = os.RemoveAll(filepath.Join(config.Uploads.Dir, r.uploads))
+	}
+}
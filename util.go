@@ -1,8 +1,12 @@
 package main
 
 import (
+	"maps"
 	"net/http"
+	"net/url"
 	"sync"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type MuMap[K comparable, V any] struct {
@@ -45,8 +49,46 @@ func flush[A any](ch chan A) {
 	}
 }
 
+// roomParam returns the room name from a request's path.
+func roomParam(r *http.Request) string {
+	return pathParam(r, "name")
+}
+
+// pathParam returns a URL parameter from a request's path. The router
+// matches on the escaped path when it has to, e.g. for a name with a slash
+// in it, leaving the parameter escaped.
+func pathParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if r.URL.RawPath == "" {
+		return value
+	}
+
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+
+	return value
+}
+
 func execute(w http.ResponseWriter, name string, data any) {
 	if err := templates.ExecuteTemplate(w, name, data); err != nil {
 		http.Error(w, "Internal server error.", http.StatusInternalServerError)
 	}
 }
+
+func (m *MuMap[K, V]) Values() []V {
+	m.Mu.RLock()
+	defer m.Mu.RUnlock()
+	values := make([]V, 0, len(m.M))
+	for _, v := range m.M {
+		values = append(values, v)
+	}
+	return values
+}
+
+// Clone returns a copy of the map, for iterating without holding the lock.
+func (m *MuMap[K, V]) Clone() map[K]V {
+	m.Mu.RLock()
+	defer m.Mu.RUnlock()
+	return maps.Clone(m.M)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const defaultWatchdogTimeout = 10 * time.Second
+
+type WatchdogConfig struct {
+	// Timeout is how long a room's loop can go without coming round before
+	// it's reported stuck, e.g. "10s".
+	Timeout string `json:"timeout"`
+
+	// Restart replaces stuck rooms with new ones that keep their members.
+	Restart bool `json:"restart"`
+}
+
+var watchdogTimeout = defaultWatchdogTimeout
+
+// StartWatchdog checks every room's loop in the background, reporting the
+// ones that stop draining their channels and restarting them if the
+// config asks for it.
+func StartWatchdog(c WatchdogConfig) error {
+	if c.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid watchdog timeout: %s", c.Timeout)
+		}
+		watchdogTimeout = timeout
+	}
+
+	go func() {
+		ticker := time.NewTicker(watchdogTimeout / 2)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for name, room := range Rooms.Clone() {
+				checkRoom(name, room, c.Restart)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// idle is how long it's been since the room's loop last came round. The
+// loop's ticker brings it round every second, so an idle room stays near
+// zero and only a stuck one climbs.
+func (r *Room) idle() time.Duration {
+	beat := r.beat.Load()
+	if beat == 0 {
+		return 0
+	}
+
+	return time.Since(time.Unix(0, beat))
+}
+
+func (r *Room) stuck() bool {
+	return r.idle() > watchdogTimeout
+}
+
+// checkRoom reports and optionally restarts a stuck room. The room is
+// logged under the name it's listed by, since room.Name belongs to its
+// loop.
+func checkRoom(name string, room *Room, restart bool) {
+	logger := slog.With("room", name)
+
+	if !room.stuck() {
+		if room.stalled.CompareAndSwap(true, false) {
+			logger.Warn("room loop recovered")
+		}
+		return
+	}
+
+	if room.stalled.CompareAndSwap(false, true) {
+		Metrics.Stalls.Add(1)
+		logger.Error("room loop stuck",
+			"idle", room.idle(),
+			"external", len(room.External),
+			"internal", len(room.Internal),
+			"register", len(room.Register),
+			"unregister", len(room.Unregister),
+			"queries", len(room.Queries),
+		)
+	}
+
+	if restart {
+		restartRoom(room)
+	}
+}
+
+// roomState is a copy of a room's state that a restart can pick up from.
+// Nothing in it is shared with the room that saved it.
+type roomState struct {
+	clients map[*Client]ClientDataExternal
+	rules   Rules
+	history []RoomMessage
+
+	webhooks []*Webhook
+	hooks    []*IncomingHook
+
+	uploads     string
+	uploadBytes int64
+
+	deliveries map[string]*DeliveryState
+	polls      map[string]*pollState
+
+	mutes      map[any]time.Time
+	filterHits map[any]int
+
+	auditLog []AuditEntry
+
+	reports  []*Report
+	reportID int
+
+	trace bool
+}
+
+// checkpoint saves a copy of the room's state for the watchdog to restart
+// it from. It runs on the room's goroutine, since nothing else can read
+// the state without racing the loop.
+func (r *Room) checkpoint() {
+	state := &roomState{
+		clients: maps.Clone(r.Clients),
+		rules:   *r.Rules,
+
+		// Messages are replaced rather than changed in place, so copying
+		// the slice is enough
+		history: slices.Clone(r.history),
+
+		webhooks: slices.Clone(r.webhooks),
+		hooks:    slices.Clone(r.hooks),
+
+		uploads:     r.uploads,
+		uploadBytes: r.uploadBytes,
+
+		deliveries: make(map[string]*DeliveryState, len(r.deliveries)),
+		polls:      make(map[string]*pollState, len(r.polls)),
+
+		mutes:      maps.Clone(r.mutes),
+		filterHits: maps.Clone(r.filterHits),
+
+		auditLog: slices.Clone(r.auditLog),
+
+		reports:  make([]*Report, 0, len(r.reports)),
+		reportID: r.reportID,
+
+		trace: r.trace,
+	}
+
+	state.rules.pins = slices.Clone(r.Rules.pins)
+	state.rules.filters = slices.Clone(r.Rules.filters)
+
+	for id, delivery := range r.deliveries {
+		delivery := *delivery
+		state.deliveries[id] = &delivery
+	}
+
+	for id, poll := range r.polls {
+		poll := *poll
+		poll.options = slices.Clone(poll.options)
+		poll.votes = maps.Clone(poll.votes)
+		state.polls[id] = &poll
+	}
+
+	for _, report := range r.reports {
+		report := *report
+		report.Context = slices.Clone(report.Context)
+		state.reports = append(state.reports, &report)
+	}
+
+	r.saved.Store(state)
+}
+
+func (s *roomState) restore(room *Room) {
+	rules := s.rules
+
+	room.Clients = s.clients
+	room.Rules = &rules
+	room.history = s.history
+	room.webhooks = s.webhooks
+	room.hooks = s.hooks
+	room.uploads = s.uploads
+	room.uploadBytes = s.uploadBytes
+	room.deliveries = s.deliveries
+	room.polls = s.polls
+	room.mutes = s.mutes
+	room.filterHits = s.filterHits
+	room.auditLog = s.auditLog
+	room.reports = s.reports
+	room.reportID = s.reportID
+	room.trace = s.trace
+}
+
+// restartRoom replaces a stuck room with a new loop under the same name,
+// started from the last checkpoint of the old one's state, so anything
+// that changed in the second before it got stuck is lost. The old loop
+// may only be slow rather than deadlocked, so it keeps its own state; if
+// it ever comes round again, it sees it's been replaced and exits.
+func restartRoom(old *Room) {
+	state := old.saved.Load()
+	if state == nil {
+		return
+	}
+
+	// The room's name is only safe to read on its own goroutine
+	Rooms.Mu.Lock()
+	name, listed := "", false
+	for test, room := range Rooms.M {
+		if room == old {
+			name, listed = test, true
+			break
+		}
+	}
+
+	room := NewRoom(name)
+	if !listed || !old.replaced.CompareAndSwap(nil, room) {
+		// The old loop has exited or was already replaced
+		Rooms.Mu.Unlock()
+		return
+	}
+
+	Rooms.M[name] = room
+	Rooms.Mu.Unlock()
+
+	state.restore(room)
+
+	// Bots are attached again, so only people move across
+	for client, data := range room.Clients {
+		if client.Bot != nil {
+			delete(room.Clients, client)
+			continue
+		}
+
+		if data.Nick != "" {
+			Directory.Remove(data.Nick, client)
+			Directory.Add(data.Nick, client, room)
+		}
+	}
+
+	close(old.closed)
+
+	room.enqueue(RoomMessage{
+		Type: MessageTypeNotice,
+		Body: "this room stopped responding and was restarted",
+	}.Fill())
+
+	members := room.humans()
+
+	go room.Run()
+	go forward(old, room)
+	AttachBots(room)
+
+	Metrics.Restarts.Add(1)
+	slog.Warn("room restarted", "room", name, "members", members)
+}
+
+// forward passes on what's sent to a replaced room, so clients that
+// haven't followed it to its replacement yet aren't left blocked.
+func forward(old *Room, room *Room) {
+	for {
+		select {
+		case message := <-old.External:
+			select {
+			case room.External <- message:
+			case <-room.closed:
+				return
+			}
+
+		case message := <-old.Internal:
+			select {
+			case room.Internal <- message:
+			case <-room.closed:
+				return
+			}
+
+		case req := <-old.Register:
+			select {
+			case room.Register <- req:
+			case <-room.closed:
+				return
+			}
+
+		case req := <-old.Unregister:
+			select {
+			case room.Unregister <- req:
+			case <-room.closed:
+				return
+			}
+
+		case req := <-old.Queries:
+			select {
+			case room.Queries <- req:
+			case <-room.closed:
+				return
+			}
+
+		case <-room.closed:
+			return
+		}
+	}
+}
+
+// follow moves the client to its room's replacement if the room was
+// restarted, registering again in case it joined after the checkpoint the
+// replacement started from, or back to the lobby if the room has closed.
+func (c *Client) follow() {
+	moved := false
+	for c.Room != nil {
+		next := c.Room.replaced.Load()
+		if next == nil || next == c.Room {
+			break
+		}
+		c.Room = next
+		moved = true
+	}
+
+	// The room's loop exited without handing over, e.g. it was closed and
+	// the client's exit was dropped, so go back to the lobby rather than
+	// send to a room no one is reading
+	if c.Room != nil && c.Room.replaced.Load() == c.Room {
+		c.Room = nil
+		c.Send <- RoomMessage{
+			Type: MessageTypeReset,
+		}
+		c.join(DefaultRoom, nil)
+		return
+	}
+
+	if moved {
+		c.Room.Register <- RegisterRequest{
+			Client:    c,
+			WantsNick: c.Data.Nick,
+			Restored:  true,
+		}
+	}
+}
+
+// StuckRooms lists the rooms whose loops the watchdog considers stuck.
+func StuckRooms() []string {
+	var names []string
+	for name, room := range Rooms.Clone() {
+		if room.stuck() {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// HealthHandler reports that the server is up and serving requests.
+func HealthHandler(w http.ResponseWriter, _ *http.Request) {
+	apiJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyHandler reports whether the server can take users: the lobby is
+// open and its loop isn't stuck. Other stuck rooms are listed, but only
+// affect the people in them, so they don't take the server out of
+// rotation.
+func ReadyHandler(w http.ResponseWriter, _ *http.Request) {
+	lobby, ok := Rooms.Get(DefaultRoom)
+	if !ok {
+		apiJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "starting"})
+		return
+	}
+
+	body := map[string]any{"status": "ok"}
+	if stuck := StuckRooms(); len(stuck) > 0 {
+		body["rooms"] = stuck
+	}
+
+	if lobby.stuck() {
+		body["status"] = "stuck"
+		apiJSON(w, http.StatusServiceUnavailable, body)
+		return
+	}
+
+	apiJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type WebhookEvent string
+
+const (
+	WebhookEventMessage WebhookEvent = "message"
+	WebhookEventJoin    WebhookEvent = "join"
+	WebhookEventLeave   WebhookEvent = "leave"
+	WebhookEventNick    WebhookEvent = "nick"
+	WebhookEventKick    WebhookEvent = "kick"
+	WebhookEventTopic   WebhookEvent = "topic"
+)
+
+var webhookEvents = []WebhookEvent{
+	WebhookEventMessage,
+	WebhookEventJoin,
+	WebhookEventLeave,
+	WebhookEventNick,
+	WebhookEventKick,
+	WebhookEventTopic,
+}
+
+const (
+	webhookMaxAttempts = 5
+	webhookQueueSize   = 256
+	webhookLogSize     = 20
+	webhookMaxPerRoom  = 10
+)
+
+// webhookBackoff is the delay before the first retry, doubling after each
+// failed attempt.
+var webhookBackoff = time.Second
+
+var errWebhookPrivateAddress = errors.New("webhook address is not public")
+
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: webhookDialControl,
+		}).DialContext,
+	},
+}
+
+// webhookDialControl refuses connections to loopback and private addresses
+// unless the config allows them, checked at dial time so DNS can't be used
+// to sneak past it.
+func webhookDialControl(_, address string, _ syscall.RawConn) error {
+	if config.AllowPrivateWebhooks {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return errWebhookPrivateAddress
+	}
+
+	return nil
+}
+
+func ParseWebhookEvents(list string) (map[WebhookEvent]bool, error) {
+	events := make(map[WebhookEvent]bool)
+
+	if list == "" || list == "all" {
+		for _, event := range webhookEvents {
+			events[event] = true
+		}
+		return events, nil
+	}
+
+	for _, name := range strings.Split(list, ",") {
+		event := WebhookEvent(strings.TrimSpace(name))
+
+		known := false
+		for _, test := range webhookEvents {
+			if test == event {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return nil, fmt.Errorf("unknown webhook event: %s", event)
+		}
+
+		events[event] = true
+	}
+
+	return events, nil
+}
+
+type WebhookPayload struct {
+	ID      string       `json:"id"`
+	Event   WebhookEvent `json:"event"`
+	Room    string       `json:"room"`
+	Message RoomMessage  `json:"message"`
+}
+
+type WebhookDelivery struct {
+	ID       string
+	Event    WebhookEvent
+	Time     time.Time
+	Attempts int
+	Status   int
+	Error    string
+}
+
+type Webhook struct {
+	ID     string
+	URL    string
+	Secret string
+	Events map[WebhookEvent]bool
+
+	queue chan WebhookPayload
+
+	mu  sync.Mutex
+	log []WebhookDelivery
+}
+
+func NewWebhook(rawURL string, events map[WebhookEvent]bool) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url: %s", rawURL)
+	}
+
+	h := &Webhook{
+		ID:     randomToken(4),
+		URL:    u.String(),
+		Secret: randomToken(16),
+		Events: events,
+		queue:  make(chan WebhookPayload, webhookQueueSize),
+	}
+
+	go h.run()
+
+	return h, nil
+}
+
+// Enqueue schedules a payload for delivery without blocking the room. If
+// the queue is full the payload is dropped and recorded as such.
+func (h *Webhook) Enqueue(payload WebhookPayload) {
+	select {
+	case h.queue <- payload:
+	default:
+		h.record(WebhookDelivery{
+			ID:    payload.ID,
+			Event: payload.Event,
+			Time:  time.Now(),
+			Error: "dropped: delivery queue full",
+		})
+	}
+}
+
+func (h *Webhook) Close() {
+	close(h.queue)
+}
+
+func (h *Webhook) Log() []WebhookDelivery {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	log := make([]WebhookDelivery, len(h.log))
+	copy(log, h.log)
+	return log
+}
+
+func (h *Webhook) record(delivery WebhookDelivery) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.log = append(h.log, delivery)
+	if len(h.log) > webhookLogSize {
+		h.log = h.log[len(h.log)-webhookLogSize:]
+	}
+}
+
+func (h *Webhook) run() {
+	for payload := range h.queue {
+		h.record(h.deliver(payload))
+	}
+}
+
+func (h *Webhook) deliver(payload WebhookPayload) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID:    payload.ID,
+		Event: payload.Event,
+		Time:  time.Now(),
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	backoff := webhookBackoff
+	for delivery.Attempts < webhookMaxAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		delivery.Attempts++
+
+		delivery.Status, err = h.post(payload, body)
+		if err != nil {
+			delivery.Error = err.Error()
+			if errors.Is(err, errWebhookPrivateAddress) {
+				return delivery
+			}
+			continue
+		}
+
+		if delivery.Status >= 200 && delivery.Status < 300 {
+			delivery.Error = ""
+			return delivery
+		}
+
+		delivery.Error = http.StatusText(delivery.Status)
+	}
+
+	return delivery
+}
+
+func (h *Webhook) post(payload WebhookPayload, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookClient.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chat-Event", string(payload.Event))
+	req.Header.Set("X-Chat-Delivery", payload.ID)
+	req.Header.Set("X-Chat-Signature", "sha256="+SignWebhook(h.Secret, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of body, as sent in the
+// X-Chat-Signature header.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// emit queues a webhook delivery of message to every hook subscribed to
+// event.
+func (r *Room) emit(event WebhookEvent, message RoomMessage) {
+	for _, hook := range r.webhooks {
+		if !hook.Events[event] {
+			continue
+		}
+
+		hook.Enqueue(WebhookPayload{
+			ID:      randomToken(8),
+			Event:   event,
+			Room:    r.Name,
+			Message: message,
+		})
+	}
+}
+
+func (r *Room) webhook(client *Client, args []string) {
+	reply := func(messageType MessageType, body string) {
+		r.enqueue(RoomMessage{
+			Type: messageType,
+			Body: body,
+			Target: Target{
+				Type:   TargetTypeOne,
+				Client: client,
+			},
+		}.Fill())
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 {
+			reply(MessageTypeError, "usage: /webhook add <url> [events]")
+			return
+		}
+
+		if len(r.webhooks) >= webhookMaxPerRoom {
+			reply(MessageTypeError, fmt.Sprintf("rooms can have at most %d webhooks", webhookMaxPerRoom))
+			return
+		}
+
+		var list string
+		if len(args) > 2 {
+			list = args[2]
+		}
+
+		events, err := ParseWebhookEvents(list)
+		if err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+
+		hook, err := NewWebhook(args[1], events)
+		if err != nil {
+			reply(MessageTypeError, err.Error())
+			return
+		}
+
+		r.webhooks = append(r.webhooks, hook)
+		reply(MessageTypeNotice, fmt.Sprintf("webhook %s added for %s\nsigning secret: %s", hook.ID, hook.URL, hook.Secret))
+
+	case "remove":
+		if len(args) < 2 {
+			reply(MessageTypeError, "usage: /webhook remove <id>")
+			return
+		}
+
+		for i, hook := range r.webhooks {
+			if hook.ID == args[1] {
+				hook.Close()
+				r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+				reply(MessageTypeNotice, fmt.Sprintf("webhook %s removed", hook.ID))
+				return
+			}
+		}
+
+		reply(MessageTypeError, fmt.Sprintf("no webhook with id %s", args[1]))
+
+	case "list":
+		if len(r.webhooks) == 0 {
+			reply(MessageTypeCommand, "no webhooks are configured")
+			return
+		}
+
+		lines := make([]string, 0, len(r.webhooks))
+		for _, hook := range r.webhooks {
+			events := make([]string, 0, len(hook.Events))
+			for _, event := range webhookEvents {
+				if hook.Events[event] {
+					events = append(events, string(event))
+				}
+			}
+
+			lines = append(lines, fmt.Sprintf("%s %s (%s)", hook.ID, hook.URL, strings.Join(events, ",")))
+		}
+
+		reply(MessageTypeCommand, fmt.Sprintf("webhooks:\n%s", strings.Join(lines, "\n")))
+
+	case "log":
+		if len(args) < 2 {
+			reply(MessageTypeError, "usage: /webhook log <id>")
+			return
+		}
+
+		for _, hook := range r.webhooks {
+			if hook.ID != args[1] {
+				continue
+			}
+
+			log := hook.Log()
+			if len(log) == 0 {
+				reply(MessageTypeCommand, fmt.Sprintf("no deliveries for webhook %s yet", hook.ID))
+				return
+			}
+
+			lines := make([]string, 0, len(log))
+			for _, delivery := range log {
+				result := fmt.Sprintf("%d", delivery.Status)
+				if delivery.Error != "" {
+					result = fmt.Sprintf("failed (%s)", delivery.Error)
+				}
+
+				lines = append(lines, fmt.Sprintf("[%s] %s %s: %s after %d attempt(s)",
+					delivery.Time.Format("15:04:05"), delivery.ID, delivery.Event, result, delivery.Attempts))
+			}
+
+			reply(MessageTypeCommand, fmt.Sprintf("deliveries for webhook %s:\n%s", hook.ID, strings.Join(lines, "\n")))
+			return
+		}
+
+		reply(MessageTypeError, fmt.Sprintf("no webhook with id %s", args[1]))
+
+	default:
+		reply(MessageTypeError, fmt.Sprintf("unknown webhook subcommand: %s\nUsage:\n  %s", args[0], Commands["webhook"].Help))
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// allowPrivateWebhooks lets webhooks reach httptest servers, which listen
+// on loopback, for the duration of a test.
+func allowPrivateWebhooks(t *testing.T, allow bool) {
+	t.Helper()
+
+	previous := config.AllowPrivateWebhooks
+	config.AllowPrivateWebhooks = allow
+	t.Cleanup(func() { config.AllowPrivateWebhooks = previous })
+}
+
+func fastWebhookBackoff(t *testing.T) {
+	t.Helper()
+
+	previous := webhookBackoff
+	webhookBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { webhookBackoff = previous })
+}
+
+func testWebhook(t *testing.T, url string) *Webhook {
+	t.Helper()
+
+	events, err := ParseWebhookEvents("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err := NewWebhook(url, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(hook.Close)
+
+	return hook
+}
+
+func testPayload() WebhookPayload {
+	return WebhookPayload{
+		ID:    "delivery",
+		Event: WebhookEventMessage,
+		Room:  "r1",
+		Message: RoomMessage{
+			ID:   "msg-1",
+			Type: MessageTypeMessage,
+			Nick: "alice",
+			Body: "hello",
+		},
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	allowPrivateWebhooks(t, true)
+
+	var (
+		body      []byte
+		signature string
+		event     string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Chat-Signature")
+		event = r.Header.Get("X-Chat-Event")
+	}))
+	defer server.Close()
+
+	hook := testWebhook(t, server.URL)
+	delivery := hook.deliver(testPayload())
+
+	if delivery.Status != http.StatusOK || delivery.Error != "" || delivery.Attempts != 1 {
+		t.Fatalf("delivery = %+v, want a single successful attempt", delivery)
+	}
+
+	if want := "sha256=" + SignWebhook(hook.Secret, body); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	if signature == "sha256="+SignWebhook("wrong secret", body) {
+		t.Error("signature doesn't depend on the secret")
+	}
+
+	if event != string(WebhookEventMessage) {
+		t.Errorf("event header = %q, want %q", event, WebhookEventMessage)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Room != "r1" || payload.Message.Body != "hello" {
+		t.Errorf("payload = %+v, want room r1 and body hello", payload)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	allowPrivateWebhooks(t, true)
+	fastWebhookBackoff(t)
+
+	var (
+		mu       sync.Mutex
+		attempts []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	delivery := testWebhook(t, server.URL).deliver(testPayload())
+
+	if delivery.Status != http.StatusOK || delivery.Error != "" || delivery.Attempts != 3 {
+		t.Fatalf("delivery = %+v, want success on the third attempt", delivery)
+	}
+
+	// The delay doubles after each failure
+	first, second := attempts[1].Sub(attempts[0]), attempts[2].Sub(attempts[1])
+	if first < webhookBackoff {
+		t.Errorf("first retry after %s, want at least %s", first, webhookBackoff)
+	}
+	if second < 2*webhookBackoff {
+		t.Errorf("second retry after %s, want at least %s", second, 2*webhookBackoff)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	allowPrivateWebhooks(t, true)
+	fastWebhookBackoff(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	delivery := testWebhook(t, server.URL).deliver(testPayload())
+
+	if delivery.Attempts != webhookMaxAttempts {
+		t.Errorf("attempts = %d, want %d", delivery.Attempts, webhookMaxAttempts)
+	}
+	if delivery.Status != http.StatusServiceUnavailable || delivery.Error != http.StatusText(http.StatusServiceUnavailable) {
+		t.Errorf("delivery = %+v, want it to fail with the last status", delivery)
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	allowPrivateWebhooks(t, false)
+	fastWebhookBackoff(t)
+
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer server.Close()
+
+	delivery := testWebhook(t, server.URL).deliver(testPayload())
+
+	if reached {
+		t.Error("webhook reached a loopback server")
+	}
+	if delivery.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1, since private addresses aren't retried", delivery.Attempts)
+	}
+	if delivery.Error == "" {
+		t.Error("delivery succeeded, want it refused")
+	}
+}
+
+func TestWebhookDialControl(t *testing.T) {
+	allowPrivateWebhooks(t, false)
+
+	tests := []struct {
+		address string
+		private bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:443", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:80", true},
+		{"192.168.1.1:8080", true},
+		{"169.254.169.254:80", true},
+		{"0.0.0.0:80", true},
+		{"[fe80::1]:80", true},
+		{"[fd00::1]:80", true},
+		{"93.184.216.34:443", false},
+		{"[2606:4700::1111]:443", false},
+	}
+
+	for _, test := range tests {
+		err := webhookDialControl("tcp", test.address, nil)
+		if private := errors.Is(err, errWebhookPrivateAddress); private != test.private {
+			t.Errorf("webhookDialControl(%s) = %v, want private %t", test.address, err, test.private)
+		}
+	}
+}
+
+func TestParseWebhookEvents(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []WebhookEvent
+		wantErr bool
+	}{
+		{"", webhookEvents, false},
+		{"all", webhookEvents, false},
+		{"message", []WebhookEvent{WebhookEventMessage}, false},
+		{"join, leave", []WebhookEvent{WebhookEventJoin, WebhookEventLeave}, false},
+		{"message,bogus", nil, true},
+	}
+
+	for _, test := range tests {
+		events, err := ParseWebhookEvents(test.list)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseWebhookEvents(%q) error = %v, want error %t", test.list, err, test.wantErr)
+			continue
+		}
+
+		if len(events) != len(test.want) {
+			t.Errorf("ParseWebhookEvents(%q) = %v, want %v", test.list, events, test.want)
+			continue
+		}
+		for _, event := range test.want {
+			if !events[event] {
+				t.Errorf("ParseWebhookEvents(%q) is missing %s", test.list, event)
+			}
+		}
+	}
+}
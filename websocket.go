@@ -3,10 +3,36 @@ package main
 import (
 	"github.com/gorilla/websocket"
 	"net/http"
+	"strings"
 )
 
 type WSFrame struct {
 	Message string `json:"message"`
+
+	// Typing, if set, makes this a typing start or stop frame
+	Typing *bool `json:"typing,omitempty"`
+}
+
+// ClientMessage converts a frame into a message from the client, reporting
+// false if there's nothing to send.
+func (f WSFrame) ClientMessage(c *Client) (ClientMessage, bool) {
+	if f.Typing != nil {
+		return ClientMessage{
+			Type:   MessageTypeTyping,
+			Client: c,
+			Typing: *f.Typing,
+		}, true
+	}
+
+	if strings.TrimSpace(f.Message) == "" {
+		return ClientMessage{}, false
+	}
+
+	return ClientMessage{
+		Type:   MessageTypeMessage,
+		Client: c,
+		Body:   f.Message,
+	}, true
 }
 
 // Configure the upgrader
@@ -21,11 +47,57 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t *wsTransport) ReadFrame() (WSFrame, error) {
+	var frame WSFrame
+	err := t.conn.ReadJSON(&frame)
+	return frame, err
+}
+
+func (t *wsTransport) WriteMessage(msg RoomMessage) error {
+	return t.conn.WriteMessage(websocket.TextMessage, []byte(msg.Render()))
+}
+
+func (t *wsTransport) RemoteAddr() string {
+	return t.conn.RemoteAddr().String()
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
+
 func WSHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	stream, ok := streamParam(r)
+	if !ok {
+		http.Error(w, "Invalid stream.", http.StatusBadRequest)
+		return
+	}
+
+	id, ok := sessionID(r)
+
+	header := http.Header{}
+	if !ok {
+		cookie := newSessionCookie()
+		id = cookie.Value
+		header.Add("Set-Cookie", cookie.String())
+	}
+
+	conn, err := upgrader.Upgrade(w, r, header)
 	if err != nil {
+		Metrics.UpgradeFailures.Add(1)
+		return
+	}
+
+	// Probes only check that a socket can be opened
+	if r.URL.Query().Has("probe") {
+		_ = conn.Close()
 		return
 	}
 
-	NewClient(conn).Serve()
+	client := NewClient(id, &wsTransport{conn: conn})
+	client.Stream = stream
+	client.Serve()
 }